@@ -0,0 +1,177 @@
+package mysqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned (wrapped) by Healthy when the server process is gone, e.g. because
+// it crashed.
+var ErrServerExited = errors.New("Server process exited")
+
+// Check that the server process is still running and answers a ping before
+// the context is done.
+//
+// A server that has exited returns ErrServerExited, so it can be told apart
+// from one that merely didn't respond in time.
+func (p *MySQL) Healthy(ctx context.Context) error {
+	if p.killed || (p.serverPID != 0 && !processAlive(p.serverPID)) {
+		return fmt.Errorf("%w (pid %d)\nERROR LOG: %s", ErrServerExited, p.serverPID, p.errorLogTail(20))
+	}
+
+	err := p.DB.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Server not responding: %w", err)
+	}
+	return nil
+}
+
+// Toggle read-only mode for the whole server.
+//
+// On MySQL this sets super_read_only, which also rejects writes from root
+// (and thus from DB). MariaDB only has read_only, which doesn't apply to
+// users with the SUPER privilege such as root: connect as a regular user to
+// observe rejected writes there.
+func (p *MySQL) SetReadOnly(ro bool) error {
+	value := "OFF"
+	if ro {
+		value = "ON"
+	}
+
+	variable := "read_only"
+	if !p.isMariaDB {
+		variable = "super_read_only"
+	}
+
+	// Disabling read_only also disables super_read_only, enabling
+	// super_read_only enables read_only.
+	if !ro {
+		variable = "read_only"
+	}
+
+	_, err := p.DB.Exec(fmt.Sprintf("SET GLOBAL %s = %s", variable, value))
+	if err != nil {
+		return fmt.Errorf("Failed to set %s: %w", variable, err)
+	}
+	return nil
+}
+
+// Block all writes by flushing tables with a global read lock, e.g. to take a
+// consistent copy of the data directory. Writes resume once release is
+// called.
+//
+// The lock is held by a dedicated connection, so DB can still be used for
+// reads in the meantime.
+func (p *MySQL) Quiesce() (release func(), err error) {
+	ctx := context.Background()
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to lock tables: %w", err)
+	}
+
+	return func() {
+		conn.ExecContext(ctx, "UNLOCK TABLES")
+		conn.Close()
+	}, nil
+}
+
+// Remove all rows from every table in the test database, keeping the schema.
+// Auto-increment counters start over.
+//
+// Foreign key checks are disabled while truncating, so tables can be cleared
+// in any order.
+func (p *MySQL) Truncate() error {
+	tables, err := p.tableNames()
+	if err != nil {
+		return err
+	}
+
+	// FOREIGN_KEY_CHECKS is per session
+	ctx := context.Background()
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	for _, table := range tables {
+		_, err = conn.ExecContext(ctx, "TRUNCATE TABLE "+quoteIdent(table))
+		if err != nil {
+			return fmt.Errorf("Failed to truncate %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Remove all binary logs except the one currently being written.
+func (p *MySQL) PurgeBinlogs() error {
+	_, err := p.DB.Exec("FLUSH BINARY LOGS")
+	if err != nil {
+		return fmt.Errorf("Failed to rotate binary logs: %w", err)
+	}
+
+	file, _, err := p.BinlogPosition()
+	if err != nil {
+		return err
+	}
+
+	// Not allowed as a prepared statement, file names are server generated
+	_, err = p.DB.Exec(fmt.Sprintf("PURGE BINARY LOGS TO '%s'", file))
+	if err != nil {
+		return fmt.Errorf("Failed to purge binary logs: %w", err)
+	}
+	return nil
+}
+
+// Force InnoDB to flush all dirty pages to disk and wait until it did, e.g.
+// before simulating a crash. Gives up after 30 seconds.
+func (p *MySQL) Flush() error {
+	_, err := p.DB.Exec("FLUSH TABLES")
+	if err != nil {
+		return fmt.Errorf("Failed to flush tables: %w", err)
+	}
+
+	// Temporarily have the page cleaners flush everything
+	var pct string
+	err = p.DB.QueryRow("SELECT @@global.innodb_max_dirty_pages_pct").Scan(&pct)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.DB.Exec("SET GLOBAL innodb_max_dirty_pages_pct = 0")
+	if err != nil {
+		return err
+	}
+	defer p.DB.Exec("SET GLOBAL innodb_max_dirty_pages_pct = " + pct)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		var name string
+		var dirty int64
+		err = p.DB.QueryRow("SHOW GLOBAL STATUS LIKE 'Innodb_buffer_pool_pages_dirty'").Scan(&name, &dirty)
+		if err != nil {
+			return err
+		}
+		if dirty == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for flush, %d pages still dirty", dirty)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Run a query returning a single JSON value and check that it's semantically
+// equal to want, ignoring key order and formatting.
+func (p *MySQL) AssertJSONEqual(tb testing.TB, query string, want string) {
+	tb.Helper()
+
+	var got string
+	err := p.DB.QueryRow(query).Scan(&got)
+	if err != nil {
+		tb.Errorf("Failed to query JSON: %s", err)
+		return
+	}
+
+	var gotValue, wantValue interface{}
+	err = json.Unmarshal([]byte(got), &gotValue)
+	if err != nil {
+		tb.Errorf("Query returned invalid JSON: %s\n%s", err, got)
+		return
+	}
+
+	err = json.Unmarshal([]byte(want), &wantValue)
+	if err != nil {
+		tb.Errorf("Expected value is invalid JSON: %s\n%s", err, want)
+		return
+	}
+
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		tb.Errorf("JSON not equal:\nexpected: %s\nactual:   %s", want, got)
+	}
+}
+
+// Check that a table has the same number of rows in two databases of this
+// instance.
+func (p *MySQL) AssertTableCountsEqual(tb testing.TB, dbA, dbB, table string) {
+	tb.Helper()
+
+	var countA, countB int64
+	err := p.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", quoteIdent(dbA), quoteIdent(table))).Scan(&countA)
+	if err != nil {
+		tb.Errorf("Failed to count %s.%s: %s", dbA, table, err)
+		return
+	}
+
+	err = p.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", quoteIdent(dbB), quoteIdent(table))).Scan(&countB)
+	if err != nil {
+		tb.Errorf("Failed to count %s.%s: %s", dbB, table, err)
+		return
+	}
+
+	if countA != countB {
+		tb.Errorf("Row counts of %s differ: %s has %d, %s has %d", table, dbA, countA, dbB, countB)
+	}
+}
+
+// Check that a table has identical contents in two databases of this
+// instance, using CHECKSUM TABLE. Both tables need the same definition for
+// the checksums to be comparable.
+func (p *MySQL) AssertTableChecksumsEqual(tb testing.TB, dbA, dbB, table string) {
+	tb.Helper()
+
+	sumA, err := p.checksumTable(dbA, table)
+	if err != nil {
+		tb.Errorf("Failed to checksum %s.%s: %s", dbA, table, err)
+		return
+	}
+
+	sumB, err := p.checksumTable(dbB, table)
+	if err != nil {
+		tb.Errorf("Failed to checksum %s.%s: %s", dbB, table, err)
+		return
+	}
+
+	if sumA != sumB {
+		tb.Errorf("Contents of %s differ between %s and %s", table, dbA, dbB)
+	}
+}
+
+func (p *MySQL) checksumTable(db, table string) (int64, error) {
+	var name string
+	var sum sql.NullInt64
+	err := p.DB.QueryRow(fmt.Sprintf("CHECKSUM TABLE %s.%s", quoteIdent(db), quoteIdent(table))).Scan(&name, &sum)
+	if err != nil {
+		return 0, err
+	}
+	if !sum.Valid {
+		return 0, fmt.Errorf("Table does not exist")
+	}
+	return sum.Int64, nil
+}
+
+// Skip the test when the server lacks spatial support (which depends on how
+// MariaDB was packaged).
+func (p *MySQL) AssertSpatialSupported(tb testing.TB) {
+	tb.Helper()
+
+	var wkt string
+	err := p.DB.QueryRow("SELECT ST_AsText(ST_GeomFromText('POINT(1 1)'))").Scan(&wkt)
+	if err != nil {
+		tb.Skipf("Spatial support not available: %s", err)
+	}
+}
+
+// Check that the plan for a query uses the named index, for any of the tables
+// involved.
+func (p *MySQL) AssertUsesIndex(tb testing.TB, index string, query string, args ...interface{}) {
+	tb.Helper()
+
+	plan, err := p.Explain(query, args...)
+	if err != nil {
+		tb.Errorf("%s", err)
+		return
+	}
+
+	if !plan.UsesIndex(index) {
+		tb.Errorf("Query does not use index %s:\n%s\nPlan: %s", index, query, plan.JSON)
+	}
+}
@@ -0,0 +1,58 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Returned by AuditLog when the server has no audit plugin available. Tests
+// can check for it with errors.Is and skip.
+var ErrAuditLogUnavailable = errors.New("Audit log plugin is not available")
+
+func auditLogSettings(isMariaDB bool, filename string) (string, map[string]string) {
+	// The loose- prefix keeps the server from refusing to start when the
+	// plugin (and thus its variables) doesn't exist.
+	if isMariaDB {
+		return "server_audit", map[string]string{
+			"loose-server_audit_logging":     "ON",
+			"loose-server_audit_output_type": "file",
+			"loose-server_audit_file_path":   filename,
+		}
+	}
+	return "audit_log.so", map[string]string{
+		"loose-audit_log_file":   filename,
+		"loose-audit_log_format": "JSON",
+	}
+}
+
+// Contents of the audit log, requires the WithAuditLog option.
+//
+// Returns ErrAuditLogUnavailable when the plugin couldn't be loaded.
+func (p *MySQL) AuditLog() (string, error) {
+	if p.auditLogFile == "" {
+		return "", fmt.Errorf("Audit log not enabled, use WithAuditLog")
+	}
+
+	var name string
+	err := p.DB.QueryRow(`SELECT plugin_name FROM information_schema.plugins
+		WHERE plugin_name IN ('SERVER_AUDIT', 'audit_log') AND plugin_status = 'ACTIVE'`).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrAuditLogUnavailable
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(p.auditLogFile)
+	if os.IsNotExist(err) {
+		// Nothing logged yet
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
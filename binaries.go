@@ -0,0 +1,73 @@
+package mysqltest
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+)
+
+// Newer MariaDB releases name their executables mariadb*, some distros no
+// longer ship the mysql* symlinks.
+var mariadbNames = map[string]string{
+	"mysqld_safe":      "mariadbd-safe",
+	"mysqld":           "mariadbd",
+	"mysql":            "mariadb",
+	"mysqladmin":       "mariadb-admin",
+	"mysql_install_db": "mariadb-install-db",
+	"mysqldump":        "mariadb-dump",
+}
+
+// Executables of the installation, resolved to the name that exists.
+type binaries struct {
+	dir   string
+	paths map[string]string
+}
+
+// Path of an executable by its mysql* name. Unresolved names (which weren't
+// found under either name) are assumed to be in the bin directory.
+func (b *binaries) path(name string) string {
+	if p, ok := b.paths[name]; ok {
+		return p
+	}
+	return path.Join(b.dir, name)
+}
+
+// Needed because Ubuntu doesn't put initdb in $PATH
+func findBinaries(dir string) (*binaries, error) {
+	if dir == "" {
+		// In $PATH (e.g. Fedora) great!
+		for _, name := range []string{serverBinary, mariadbNames[serverBinary]} {
+			p, err := exec.LookPath(name)
+			if err == nil {
+				dir = filepath.Dir(p)
+				break
+			}
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("Did not find MySQL / MariaDB executables installed")
+		}
+	}
+
+	b := &binaries{
+		dir:   filepath.ToSlash(dir),
+		paths: make(map[string]string),
+	}
+	for name, alt := range mariadbNames {
+		for _, candidate := range []string{name, alt} {
+			p := path.Join(b.dir, candidate)
+			_, err := exec.LookPath(p)
+			if err == nil {
+				b.paths[name] = p
+				break
+			}
+		}
+	}
+
+	for _, name := range []string{serverBinary, "mysql", "mysqladmin"} {
+		if _, ok := b.paths[name]; !ok {
+			return nil, fmt.Errorf("Did not find %s (or %s) in %s", name, mariadbNames[name], dir)
+		}
+	}
+	return b, nil
+}
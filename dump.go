@@ -0,0 +1,60 @@
+package mysqltest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+)
+
+// Load a dump made with mysqldump into the test database, using the mysql
+// client so everything it supports (DELIMITER, client commands, ...) works.
+//
+// Files compressed with gzip (.sql.gz) are decompressed transparently.
+func (p *MySQL) LoadDump(filename string) error {
+	r, err := openSQLFile(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	args := append(p.clientArgs(), p.dbName)
+	load := prepareCommand(p.isRoot, p.bins.path("mysql"), args...)
+	load.Stdin = r
+	out, err := combinedOutputTimeout(context.Background(), load, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s: %w -> %s", filename, err, string(out))
+	}
+	return nil
+}
+
+// Write a dump of the test database to w using mysqldump, e.g. to keep the
+// state of a failing test as a CI artifact. Extra mysqldump arguments (such as
+// --no-data) go before the database name.
+func (p *MySQL) DumpTo(w io.Writer, args ...string) error {
+	args = append(append(p.clientArgs(), args...), p.dbName)
+	dump := prepareCommand(p.isRoot, p.bins.path("mysqldump"), args...)
+
+	var stderr bytes.Buffer
+	dump.Stdout = w
+	dump.Stderr = &stderr
+	err := dump.Run()
+	if err != nil {
+		return fmt.Errorf("Failed to dump database: %w -> %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Arguments for client tools (mysql, mysqladmin, ...) to connect as root.
+func (p *MySQL) clientArgs() []string {
+	args := []string{"-u", "root", "-S", p.sockFile}
+	if runtime.GOOS == "windows" {
+		args = []string{"-u", "root", "--protocol=TCP", "-h", "127.0.0.1", "-P", strconv.Itoa(p.port)}
+	}
+	if p.rootPassword != "" {
+		args = append(args, "--password="+p.rootPassword)
+	}
+	return args
+}
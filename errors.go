@@ -0,0 +1,66 @@
+package mysqltest
+
+import "fmt"
+
+// Step of Start that failed, see StartError.
+type Phase string
+
+const (
+	// The options are invalid
+	PhaseConfig Phase = "config"
+
+	// No MySQL / MariaDB installation was found
+	PhaseFindBinary Phase = "find binary"
+
+	// Running mysql --version failed
+	PhaseVersion Phase = "version"
+
+	// Preparing the temporary storage failed (including the mysql user when
+	// running as root and conflicts with other instances)
+	PhasePrepare Phase = "prepare"
+
+	// Initializing the data directory failed (including the init cache)
+	PhaseInit Phase = "init"
+
+	// The server process couldn't be started
+	PhaseStart Phase = "start"
+
+	// The server didn't accept connections in time
+	PhaseConnect Phase = "connect"
+
+	// Setting up databases, users, schemas, etc. failed
+	PhaseSetup Phase = "setup"
+)
+
+// Returned by Start when it fails, use errors.As to find out where, e.g. to
+// skip a test when MySQL isn't installed:
+//
+//	var serr *mysqltest.StartError
+//	if errors.As(err, &serr) && serr.Phase == mysqltest.PhaseFindBinary {
+//		t.Skip(err)
+//	}
+type StartError struct {
+	Phase Phase
+
+	// Output (stdout and stderr) of the process that failed, when there is
+	// one.
+	Output string
+
+	Err error
+	msg string
+}
+
+func (e *StartError) Error() string {
+	s := e.Err.Error()
+	if e.msg != "" {
+		s = e.msg + ": " + s
+	}
+	if e.Output != "" {
+		s += fmt.Sprintf("\nOUTPUT: %s", e.Output)
+	}
+	return s
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,47 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Block until the named event in the test database runs, requires
+// WithEventScheduler.
+//
+// Only executions that start after calling this count. Note that the server
+// tracks them with a one second resolution, and that events which are dropped
+// on completion can't be waited for.
+func (p *MySQL) WaitForEvent(ctx context.Context, name string) error {
+	initial, err := p.eventLastExecuted(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Event %s did not run: %w", name, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		last, err := p.eventLastExecuted(ctx, name)
+		if err != nil {
+			return err
+		}
+		if last.Valid && last != initial {
+			return nil
+		}
+	}
+}
+
+func (p *MySQL) eventLastExecuted(ctx context.Context, name string) (sql.NullString, error) {
+	var last sql.NullString
+	err := p.DB.QueryRowContext(ctx, `SELECT last_executed FROM information_schema.events
+		WHERE event_schema = ? AND event_name = ?`, p.dbName, name).Scan(&last)
+	if err == sql.ErrNoRows {
+		return last, fmt.Errorf("Event %s not found", name)
+	}
+	return last, err
+}
@@ -0,0 +1,128 @@
+package mysqltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The query plan returned by Explain.
+type ExplainResult struct {
+	// Raw output of EXPLAIN FORMAT=JSON
+	JSON string
+
+	// Decoded plan. The layout differs between MySQL and MariaDB, use
+	// Tables for a flavor-independent view.
+	Plan map[string]interface{}
+}
+
+// A single table access in a query plan.
+type ExplainTable struct {
+	Name         string
+	AccessType   string
+	Key          string
+	PossibleKeys []string
+}
+
+// Run EXPLAIN FORMAT=JSON for the given query against the test database.
+func (p *MySQL) Explain(query string, args ...interface{}) (ExplainResult, error) {
+	var result ExplainResult
+
+	err := p.DB.QueryRow("EXPLAIN FORMAT=JSON "+query, args...).Scan(&result.JSON)
+	if err != nil {
+		return result, fmt.Errorf("Failed to explain query: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(result.JSON), &result.Plan)
+	if err != nil {
+		return result, fmt.Errorf("Failed to parse query plan: %w", err)
+	}
+
+	return result, nil
+}
+
+// All table accesses in the plan, including those of nested loops and
+// subqueries, in a stable order.
+func (r ExplainResult) Tables() []ExplainTable {
+	var tables []ExplainTable
+	collectTables(r.Plan, &tables)
+	return tables
+}
+
+func collectTables(node interface{}, tables *[]ExplainTable) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if t, ok := n["table"].(map[string]interface{}); ok {
+			*tables = append(*tables, parseExplainTable(t))
+		}
+
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collectTables(n[k], tables)
+		}
+	case []interface{}:
+		for _, v := range n {
+			collectTables(v, tables)
+		}
+	}
+}
+
+func parseExplainTable(t map[string]interface{}) ExplainTable {
+	result := ExplainTable{}
+	result.Name, _ = t["table_name"].(string)
+	result.AccessType, _ = t["access_type"].(string)
+	result.Key, _ = t["key"].(string)
+	if keys, ok := t["possible_keys"].([]interface{}); ok {
+		for _, k := range keys {
+			if s, ok := k.(string); ok {
+				result.PossibleKeys = append(result.PossibleKeys, s)
+			}
+		}
+	}
+	return result
+}
+
+// Whether any access path in the plan uses the named index, including index
+// merges.
+func (r ExplainResult) UsesIndex(index string) bool {
+	return usesIndex(r.Plan, index)
+}
+
+func usesIndex(node interface{}, index string) bool {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if key, ok := v.(string); ok && k == "key" && keyMentions(key, index) {
+				return true
+			}
+			if usesIndex(v, index) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			if usesIndex(v, index) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Index merges are reported as e.g. "union(idx_a,idx_b)"
+func keyMentions(key, index string) bool {
+	parts := strings.FieldsFunc(key, func(r rune) bool {
+		return r == '(' || r == ')' || r == ','
+	})
+	for _, part := range parts {
+		if strings.TrimSpace(part) == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package mysqltest
+
+// Scan a different directory than os.TempDir, so tests don't touch real
+// instances.
+var CleanupOrphansIn = cleanupOrphans
+
+var ParseQueryLog = parseQueryLog
+
+var SplitStatements = splitStatements
+
+var ParseVersionOutput = parseVersion
+
+// Check v against the range WithMinVersion / WithMaxVersion would set.
+func CheckVersion(min, max string, v Version) error {
+	c := newConfig([]Option{WithMinVersion(min), WithMaxVersion(max)})
+	return c.checkVersion(v)
+}
+
+var QuoteOptionValue = quoteOptionValue
@@ -0,0 +1,32 @@
+package mysqltest
+
+// First MySQL and MariaDB versions that support a feature, a zero version
+// means never.
+var features = map[string]struct{ mysql, mariadb Version }{
+	"window_functions":  {Version{8, 0, 2}, Version{10, 2, 0}},
+	"cte":               {Version{8, 0, 1}, Version{10, 2, 1}},
+	"json":              {Version{5, 7, 8}, Version{10, 2, 7}},
+	"check_constraints": {Version{8, 0, 16}, Version{10, 2, 1}},
+	"invisible_columns": {Version{8, 0, 23}, Version{10, 3, 3}},
+	"sequences":         {Version{}, Version{10, 3, 0}},
+	"returning":         {Version{}, Version{10, 5, 0}},
+	"lateral":           {Version{8, 0, 14}, Version{}},
+}
+
+// Whether the server supports a feature, based on its flavor and version:
+// "window_functions", "cte", "json", "check_constraints", "invisible_columns",
+// "sequences", "returning" or "lateral".
+//
+// Unknown features, or servers with an unknown version, report false.
+func (p *MySQL) Supports(feature string) bool {
+	f, ok := features[feature]
+	if !ok || p.version == (Version{}) {
+		return false
+	}
+
+	since := f.mysql
+	if p.isMariaDB {
+		since = f.mariadb
+	}
+	return since != (Version{}) && p.version.compare(since) >= 0
+}
@@ -1,6 +1,6 @@
 module github.com/rubenv/mysqltest
 
-go 1.13
+go 1.14
 
 require (
 	github.com/go-sql-driver/mysql v1.5.0
@@ -0,0 +1,106 @@
+package mysqltest
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache entry for an initialized data directory. Initialization depends on
+// the exact server build (the raw --version output, in case it can't be
+// parsed), the settings (e.g. innodb_page_size) and the init arguments (e.g.
+// how root authenticates), so all of those are part of the key.
+func initCacheKey(isMariaDB bool, versionOutput, settings string, initArgs []string) string {
+	flavor := "mysql"
+	if isMariaDB {
+		flavor = "mariadb"
+	}
+	h := sha256.New()
+	for _, part := range append([]string{versionOutput, settings}, initArgs...) {
+		fmt.Fprintf(h, "%s\x00", part)
+	}
+	return fmt.Sprintf("%s-%x", flavor, h.Sum(nil)[:8])
+}
+
+// Copy a cached data directory into dataDir, false when there is none yet.
+func restoreCachedInit(entry, dataDir string, keepOwner bool) (bool, error) {
+	_, err := os.Stat(entry)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = copyDir(entry, dataDir, keepOwner)
+	if err != nil {
+		return false, fmt.Errorf("Failed to copy cached data directory: %w", err)
+	}
+	return true, nil
+}
+
+// Store a freshly initialized data directory in the cache.
+func saveCachedInit(entry, dataDir string, keepOwner bool) error {
+	tmp := entry + ".tmp"
+	os.RemoveAll(tmp)
+	err := os.MkdirAll(tmp, 0711)
+	if err != nil {
+		return err
+	}
+
+	err = copyDir(dataDir, tmp, keepOwner)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("Failed to cache data directory: %w", err)
+	}
+
+	// Holds the server UUID, which each copy should generate itself
+	os.Remove(filepath.Join(tmp, "auto.cnf"))
+
+	return os.Rename(tmp, entry)
+}
+
+// Take a lock file, which other processes wait for until it's removed.
+// Locks left behind by dead processes are taken over. Unlocking more than
+// once is fine.
+func lockFile(ctx context.Context, filename string) (unlock func(), err error) {
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprint(f, os.Getpid())
+			f.Close()
+			var once sync.Once
+			return func() { once.Do(func() { os.Remove(filename) }) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err == nil {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err == nil && !processAlive(pid) {
+				os.Remove(filename)
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Waiting for lock %s: %w", filename, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
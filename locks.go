@@ -0,0 +1,119 @@
+package mysqltest
+
+import (
+	"fmt"
+)
+
+// An InnoDB lock, either held or waited for.
+type LockInfo struct {
+	LockID        string
+	TransactionID string
+
+	// Locked table, as `schema`.`table`, empty when the lock isn't on a table
+	Table string
+	Index string
+
+	// TABLE or RECORD
+	Type string
+
+	// E.g. X, S, IX, X,REC_NOT_GAP
+	Mode string
+
+	// GRANTED or WAITING
+	Status string
+
+	// Locked record, if any
+	Data string
+
+	// Transactions holding the locks this one waits for
+	BlockedBy []string
+}
+
+// List the current InnoDB locks, e.g. from a watchdog in a hanging
+// concurrency test.
+//
+// Uses performance_schema.data_locks on MySQL 8 and falls back to
+// information_schema.innodb_locks elsewhere. Note that the latter only lists
+// locks that are blocking or being waited for.
+func (p *MySQL) Locks() ([]LockInfo, error) {
+	locks, err := p.dataLocks()
+	if err == nil {
+		return locks, nil
+	}
+
+	locks, fallbackErr := p.innodbLocks()
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("Failed to list locks: %s / %s", err, fallbackErr)
+	}
+	return locks, nil
+}
+
+func (p *MySQL) dataLocks() ([]LockInfo, error) {
+	return p.queryLocks("SELECT engine_lock_id, engine_transaction_id,"+
+		" IFNULL(CONCAT('`', object_schema, '`.`', object_name, '`'), ''),"+
+		" IFNULL(index_name, ''), lock_type, lock_mode, lock_status, IFNULL(lock_data, '')"+
+		" FROM performance_schema.data_locks",
+		`SELECT requesting_engine_lock_id, blocking_engine_transaction_id
+		FROM performance_schema.data_lock_waits`)
+}
+
+func (p *MySQL) innodbLocks() ([]LockInfo, error) {
+	locks, err := p.queryLocks(`SELECT lock_id, lock_trx_id, lock_table,
+			IFNULL(lock_index, ''), lock_type, lock_mode, 'GRANTED', IFNULL(lock_data, '')
+		FROM information_schema.innodb_locks`,
+		`SELECT requested_lock_id, blocking_trx_id
+		FROM information_schema.innodb_lock_waits`)
+	if err != nil {
+		return nil, err
+	}
+
+	// No status column here, waiting locks are those that are blocked
+	for i := range locks {
+		if len(locks[i].BlockedBy) > 0 {
+			locks[i].Status = "WAITING"
+		}
+	}
+	return locks, nil
+}
+
+func (p *MySQL) queryLocks(locksQuery, waitsQuery string) ([]LockInfo, error) {
+	rows, err := p.DB.Query(locksQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []LockInfo
+	index := make(map[string]int)
+	for rows.Next() {
+		var l LockInfo
+		err = rows.Scan(&l.LockID, &l.TransactionID, &l.Table, &l.Index, &l.Type, &l.Mode, &l.Status, &l.Data)
+		if err != nil {
+			return nil, err
+		}
+		index[l.LockID] = len(locks)
+		locks = append(locks, l)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	waits, err := p.DB.Query(waitsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer waits.Close()
+
+	for waits.Next() {
+		var lockID, blocker string
+		err = waits.Scan(&lockID, &blocker)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[lockID]; ok {
+			locks[i].BlockedBy = append(locks[i].BlockedBy, blocker)
+		}
+	}
+	return locks, waits.Err()
+}
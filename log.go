@@ -0,0 +1,130 @@
+package mysqltest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single entry of the general query log.
+type LoggedQuery struct {
+	// Zero when the server didn't log a parseable time
+	Time time.Time
+
+	ConnectionID uint64
+
+	// E.g. Connect, Query, Prepare, Execute, Quit
+	Command string
+
+	// The statement for queries, connection details for Connect
+	Argument string
+}
+
+// Matches both the MySQL and MariaDB general log layouts:
+//
+//	2020-04-01T10:00:00.000000Z	    8 Query	SELECT 1
+//	200401 10:00:00	    8 Query	SELECT 1
+//			    8 Query	SELECT 1
+var queryLogLine = regexp.MustCompile(`^([^\t]*)\t+ *(\d+) ([^\t]+)\t?(.*)$`)
+
+// The general query log, parsed into entries.
+//
+// Statements spanning multiple lines are returned as a single entry.
+func (p *MySQL) QueryLog() ([]LoggedQuery, error) {
+	f, err := os.Open(path.Join(p.logDir, "out.log"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseQueryLog(f)
+}
+
+func parseQueryLog(r io.Reader) ([]LoggedQuery, error) {
+	var entries []LoggedQuery
+	var last time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isQueryLogHeader(line) {
+			continue
+		}
+
+		m := queryLogLine.FindStringSubmatch(line)
+		if m == nil {
+			// Continuation of a multi-line statement
+			if len(entries) > 0 {
+				e := &entries[len(entries)-1]
+				e.Argument += "\n" + line
+			}
+			continue
+		}
+
+		if m[1] != "" {
+			last = parseQueryLogTime(m[1])
+		}
+
+		id, _ := strconv.ParseUint(m[2], 10, 64)
+		entries = append(entries, LoggedQuery{
+			Time:         last,
+			ConnectionID: id,
+			Command:      strings.TrimSpace(m[3]),
+			Argument:     m[4],
+		})
+	}
+
+	return entries, scanner.Err()
+}
+
+// Empty the general query log, e.g. so a test only sees the queries of the
+// code under test in QueryLog.
+//
+// The server appends to the log, so it keeps writing at the start of the
+// truncated file.
+func (p *MySQL) ClearQueryLog() error {
+	err := os.Truncate(path.Join(p.logDir, "out.log"), 0)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to clear query log: %w", err)
+	}
+	return nil
+}
+
+// Lines written whenever the server (re)opens the log
+func isQueryLogHeader(line string) bool {
+	return strings.Contains(line, ", Version: ") ||
+		strings.HasPrefix(line, "Tcp port: ") ||
+		strings.HasPrefix(line, "Time ") ||
+		strings.HasPrefix(line, "Time\t")
+}
+
+func parseQueryLogTime(s string) time.Time {
+	// MySQL
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t
+	}
+
+	// MariaDB, in local time
+	t, err = time.ParseInLocation("060102 15:04:05", strings.TrimSpace(s), time.Local)
+	if err == nil {
+		return t
+	}
+
+	// MariaDB pads single digit hours: "200401  9:00:00"
+	t, err = time.ParseInLocation("060102 15:04:05", strings.Replace(strings.TrimSpace(s), "  ", " 0", 1), time.Local)
+	if err == nil {
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,88 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Status variables exported by WriteMetrics, all counters except where noted.
+var metricVariables = map[string]string{
+	"Bytes_received":                   "counter",
+	"Bytes_sent":                       "counter",
+	"Com_delete":                       "counter",
+	"Com_insert":                       "counter",
+	"Com_select":                       "counter",
+	"Com_update":                       "counter",
+	"Created_tmp_disk_tables":          "counter",
+	"Created_tmp_tables":               "counter",
+	"Innodb_buffer_pool_read_requests": "counter",
+	"Innodb_buffer_pool_reads":         "counter",
+	"Innodb_data_reads":                "counter",
+	"Innodb_data_writes":               "counter",
+	"Innodb_row_lock_waits":            "counter",
+	"Innodb_rows_deleted":              "counter",
+	"Innodb_rows_inserted":             "counter",
+	"Innodb_rows_read":                 "counter",
+	"Innodb_rows_updated":              "counter",
+	"Queries":                          "counter",
+	"Questions":                        "counter",
+	"Select_full_join":                 "counter",
+	"Select_scan":                      "counter",
+	"Slow_queries":                     "counter",
+	"Sort_merge_passes":                "counter",
+	"Threads_connected":                "gauge",
+	"Threads_running":                  "gauge",
+}
+
+// Write a selection of SHOW GLOBAL STATUS counters (query counts, InnoDB
+// activity) in the Prometheus text exposition format, named like
+// mysql_global_status_questions.
+func (p *MySQL) WriteMetrics(w io.Writer) error {
+	rows, err := p.DB.Query("SHOW GLOBAL STATUS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make(map[string]float64)
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := metricVariables[name]; !ok {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		values[name] = f
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		metric := "mysql_global_status_" + strings.ToLower(name)
+		_, err = fmt.Fprintf(w, "# TYPE %s %s\n%s %s\n", metric, metricVariables[name],
+			metric, strconv.FormatFloat(values[name], 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
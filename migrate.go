@@ -0,0 +1,78 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Apply the *.up.sql migrations in dir to the test database, in lexical order.
+// Returns the files that were applied.
+//
+// Applied migrations are tracked in a schema_migrations table, so calling it
+// again only applies new files.
+func (p *MySQL) MigrateDir(dir string) ([]string, error) {
+	_, err := p.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create schema_migrations: %w", err)
+	}
+
+	done, err := p.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted by name
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".up.sql") || done[name] {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return applied, err
+		}
+
+		err = p.execStatements(string(data))
+		if err != nil {
+			return applied, fmt.Errorf("Migration %s failed: %w", name, err)
+		}
+
+		_, err = p.DB.Exec("INSERT INTO schema_migrations (name) VALUES (?)", name)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+func (p *MySQL) appliedMigrations() (map[string]bool, error) {
+	rows, err := p.DB.Query("SELECT name FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		done[name] = true
+	}
+	return done, rows.Err()
+}
@@ -5,51 +5,143 @@
 package mysqltest
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+const readOnlyUser = "mysqltest_ro"
+
 type MySQL struct {
-	dir string
-	cmd *exec.Cmd
-	DB  *sql.DB
+	dir      string
+	dataDir  string
+	logDir   string
+	keepData bool
+	cmd      *exec.Cmd
+	DB       *sql.DB
+
+	// Server output, see ServerLog
+	output     *ringBuffer
+	outputDone chan struct{}
+
+	isRoot    bool
+	isMariaDB bool
+	version   Version
+	bins      *binaries
+	sockFile  string
+	port      int
+	dbName    string
+	dsnParams url.Values
+
+	rootPassword string
+	users        map[string]string
 
-	stderr io.ReadCloser
-	stdout io.ReadCloser
+	// Additional databases, with their handle once opened by DBFor
+	databasesMu sync.Mutex
+	databases   map[string]*sql.DB
 
-	isRoot   bool
-	binPath  string
-	sockFile string
+	errorLogFile string
+	readyLine    bool
+	initOutput   string
+	auditLogFile string
+	pluginDir    string
+
+	// PID of mysqld itself, cmd is the mysqld_safe wrapper
+	serverPID int
+	killed    bool
+
+	// How to (re)start the server
+	serverCommand   string
+	serverArgs      []string
+	startupTimeout  time.Duration
+	shutdownTimeout time.Duration
+
+	snapshots []string
 }
 
 // Start a new MySQL database, on temporary storage.
 //
 // Use the DB field to access the database connection
 func Start() (*MySQL, error) {
+	return StartWithOptions()
+}
+
+// Start a new MySQL database, on temporary storage, configured with the given
+// options.
+//
+// Use the DB field to access the database connection
+func StartWithOptions(opts ...Option) (*MySQL, error) {
+	return StartContext(context.Background(), opts...)
+}
+
+// Start a new MySQL database, like StartWithOptions, but give up when the
+// context is done before the server is ready. A half-started server is
+// stopped and its files are removed.
+//
+// The context only covers startup, cancelling it later doesn't affect the
+// running server.
+func StartContext(ctx context.Context, opts ...Option) (_ *MySQL, err error) {
+	// Errors without a phase of their own failed in the current one
+	phase := PhaseConfig
+	defer func() {
+		var serr *StartError
+		if err != nil && !errors.As(err, &serr) {
+			err = &StartError{Phase: phase, Err: err}
+		}
+	}()
+
+	cfg := newConfig(opts)
+	cfg.started = time.Now()
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find executables root path
+	bins, err := findBinaries(cfg.binPath)
+	if err != nil {
+		return nil, &StartError{Phase: PhaseFindBinary, Err: err}
+	}
+	cfg.logf("Found executables in %s", bins.dir)
+	phase = PhasePrepare
+
 	// Handle dropping permissions when running as root
 	me, err := user.Current()
 	if err != nil {
 		return nil, err
 	}
-	isRoot := me.Username == "root"
+	isRoot := me.Username == "root" && runtime.GOOS != "windows"
+
+	// Windows has no unix sockets
+	if runtime.GOOS == "windows" {
+		cfg.tcp = true
+	}
 
 	mysqlUID := int(0)
 	mysqlGID := int(0)
 	if isRoot {
 		mysqlUser, err := user.Lookup("mysql")
 		if err != nil {
-			return nil, fmt.Errorf("Could not find mysql user, which is required when running as root: %s", err)
+			return nil, fmt.Errorf("Could not find mysql user, which is required when running as root: %w", err)
 		}
 
 		uid, err := strconv.ParseInt(mysqlUser.Uid, 10, 64)
@@ -65,17 +157,118 @@ func Start() (*MySQL, error) {
 		mysqlGID = int(gid)
 	}
 
+	// Figure out what we are running
+	phase = PhaseVersion
+	version := prepareCommand(isRoot, bins.path("mysql"),
+		"--version",
+	)
+	out, err := combinedOutputTimeout(ctx, version, 0)
+	if err != nil {
+		return nil, &StartError{Phase: PhaseVersion, Output: string(out), Err: err, msg: "Failed to get version"}
+	}
+	versionOutput := strings.TrimSpace(string(out))
+	isMariaDB := strings.Contains(versionOutput, "MariaDB")
+
+	// Only fatal when it actually matters
+	serverVersion, err := parseVersion(string(out))
+	if err != nil && cfg.hasVersionRange() {
+		return nil, err
+	}
+
+	err = cfg.checkVersion(serverVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.validateFlavor(isMariaDB, serverVersion)
+	if err != nil {
+		return nil, &StartError{Phase: PhaseConfig, Err: err}
+	}
+
 	// Prepare data directory
-	dir, err := ioutil.TempDir("", "mysqltest")
+	phase = PhasePrepare
+	dir, err := ioutil.TempDir(cfg.baseDir, "mysqltest")
+	if err != nil {
+		return nil, err
+	}
+	// Backslashes are escapes in my.cnf
+	dir = filepath.ToSlash(dir)
+
+	err = writeOwnerFile(dir, cfg.keepData)
 	if err != nil {
+		os.RemoveAll(dir)
 		return nil, err
 	}
 
+	logDir := path.Join(dir, "log")
+	if cfg.logDir != "" {
+		logDir, err = ioutil.TempDir(cfg.logDir, "mysqltest")
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		logDir = filepath.ToSlash(logDir)
+	}
+
+	started := false
+	defer func() {
+		if !started && !cfg.keepData {
+			os.RemoveAll(dir)
+			os.RemoveAll(logDir)
+		}
+	}()
+
 	dataDir := path.Join(dir, "data")
+	if cfg.dataDir != "" {
+		dataDir = filepath.ToSlash(cfg.dataDir)
+	}
+	initialized := isInitialized(dataDir)
+
 	tmpDir := path.Join(dir, "tmp")
 	sockDir := path.Join(dir, "sock")
 	sockFile := path.Join(sockDir, "mysql.sock")
 
+	// Another process might be using it, the server would only fail to bind
+	if _, err := os.Lstat(sockFile); err == nil {
+		return nil, fmt.Errorf("Conflict: socket %s already exists, is another server using it?", sockFile)
+	}
+
+	err = claim("socket " + sockFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !started {
+			release("socket " + sockFile)
+		}
+	}()
+
+	networking := "skip-networking"
+	port := 0
+	if cfg.tcp {
+		// Another instance (e.g. in a Pool) can get the same port before its
+		// server binds it, pick another one then
+		for attempt := 1; ; attempt++ {
+			port, err = freePort()
+			if err != nil {
+				return nil, err
+			}
+			err = claim(fmt.Sprintf("port %d", port))
+			if err == nil {
+				break
+			}
+			if attempt == 10 {
+				return nil, err
+			}
+		}
+		defer func() {
+			if !started {
+				release(fmt.Sprintf("port %d", port))
+			}
+		}()
+		networking = fmt.Sprintf("bind_address = 127.0.0.1\nport = %d", port)
+	}
+
 	err = os.MkdirAll(dataDir, 0711)
 	if err != nil {
 		return nil, err
@@ -91,6 +284,11 @@ func Start() (*MySQL, error) {
 		return nil, err
 	}
 
+	err = os.MkdirAll(logDir, 0711)
+	if err != nil {
+		return nil, err
+	}
+
 	if isRoot {
 		err = os.Chmod(dir, 0711)
 		if err != nil {
@@ -111,8 +309,44 @@ func Start() (*MySQL, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		err = os.Chown(logDir, mysqlUID, mysqlGID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	pluginDir := ""
+	if len(cfg.pluginFiles) > 0 {
+		pluginDir = path.Join(dir, "plugin")
+		err = os.MkdirAll(pluginDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, src := range cfg.pluginFiles {
+			name := path.Base(src)
+			err = copyFile(src, path.Join(pluginDir, name), 0755)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to copy plugin: %w", err)
+			}
+			cfg.plugins = append(cfg.plugins, name)
+		}
+		cfg.set("plugin_dir", pluginDir)
+	}
+
+	auditLogFile := ""
+	if cfg.auditLog {
+		auditLogFile = path.Join(logDir, "audit.log")
+		plugin, settings := auditLogSettings(isMariaDB, auditLogFile)
+		cfg.plugins = append(cfg.plugins, plugin)
+		for k, v := range settings {
+			cfg.set(k, v)
+		}
+	}
+
+	cfg.setFlavorSettings(isMariaDB, serverVersion)
+
 	// Write config file
 	configFile := path.Join(dir, "my.cnf")
 	err = ioutil.WriteFile(configFile, []byte(fmt.Sprintf(`[mysqld]
@@ -120,156 +354,977 @@ datadir = %s
 socket = %s/mysql.sock
 general_log_file = %s/out.log
 general_log = 1
-skip-networking
-`, dataDir, sockDir, dir)), 0644)
-
-	// Find executables root path
-	binPath, err := findBinPath()
+log_error = %s/error.log
+slow_query_log_file = %s/slow.log
+server_id = %d
+%s
+%s`, dataDir, sockDir, logDir, logDir, logDir, nextServerID(), networking, cfg.mysqldSettings())), 0644)
 	if err != nil {
 		return nil, err
 	}
 
-	// Figure out what we are running
-	version := prepareCommand(isRoot, path.Join(binPath, "mysql"),
-		"--version",
-	)
-	out, err := version.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to get version: %w -> %s", err, string(out))
+	// Options that change how the data directory is initialized
+	phase = PhaseInit
+	var initOptions []string
+	if isMariaDB && cfg.tcp && serverVersion.AtLeast(10, 4, 0) {
+		// Root otherwise authenticates through unix_socket only
+		initOptions = append(initOptions, "--auth-root-authentication-method=normal")
+	}
+
+	// Copy an earlier initialization, when caching
+	fromCache := false
+	cacheEntry := ""
+	unlockCache := func() {}
+	if !initialized && cfg.initCache != "" {
+		// Paths differ for each instance (e.g. the audit log in a WithLogDir
+		// directory), the log directory can be inside dir
+		settings := strings.NewReplacer(logDir, "", dir, "").Replace(cfg.mysqldSettings())
+		cacheEntry = filepath.Join(cfg.initCache, initCacheKey(isMariaDB, versionOutput, settings, initOptions))
+		unlockCache, err = lockFile(ctx, cacheEntry+".lock")
+		if err != nil {
+			return nil, err
+		}
+		defer unlockCache()
+
+		fromCache, err = restoreCachedInit(cacheEntry, dataDir, isRoot)
+		if err != nil {
+			return nil, err
+		}
+		if fromCache {
+			cfg.logf("Copied data directory from %s", cacheEntry)
+			unlockCache()
+		}
 	}
-	isMariaDB := strings.Contains(string(out), "MariaDB")
 
 	// Initialize MySQL data directory
-	if isMariaDB {
-		init := prepareCommand(isRoot, path.Join(binPath, "mysql_install_db"),
+	if initialized || fromCache {
+		cfg.logf("Reusing initialized data directory %s", dataDir)
+		out = nil
+	} else if isMariaDB {
+		cfg.logf("Initializing data directory %s", dataDir)
+		initArgs := append([]string{
 			fmt.Sprintf("--datadir=%s", dataDir),
-		)
-		out, err = init.CombinedOutput()
+		}, initOptions...)
+		init := prepareCommand(isRoot, bins.path("mysql_install_db"), initArgs...)
+		out, err = combinedOutputTimeout(ctx, init, cfg.initTimeout)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to initialize DB: %w -> %s", err, string(out))
+			return nil, initError(err, out, cfg)
 		}
 	} else {
-		init := prepareCommand(isRoot, path.Join(binPath, "mysqld_safe"),
-			"--initialize-insecure",
+		cfg.logf("Initializing data directory %s", dataDir)
+		// mysqld wants --defaults-file first
+		init := prepareCommand(isRoot, bins.path(serverBinary),
 			fmt.Sprintf("--defaults-file=%s", configFile),
+			"--initialize-insecure",
 			fmt.Sprintf("--datadir=%s", dataDir),
 			fmt.Sprintf("--tmpdir=%s", tmpDir),
 		)
-		out, err = init.CombinedOutput()
+		out, err = combinedOutputTimeout(ctx, init, cfg.initTimeout)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to initialize DB: %w -> %s", err, string(out))
+			return nil, initError(err, out, cfg)
+		}
+	}
+	if !initialized && !fromCache {
+		cfg.logf("Initialized data directory")
+		if cacheEntry != "" {
+			err = saveCachedInit(cacheEntry, dataDir, isRoot)
+			if err != nil {
+				return nil, err
+			}
+			unlockCache()
 		}
 	}
+	initOutput := string(out)
+
+	// With log_error set, MySQL reports init warnings in the error log
+	errorLog, err := ioutil.ReadFile(path.Join(logDir, "error.log"))
+	if err == nil {
+		initOutput += string(errorLog)
+	}
+
+	// Only look at log lines written by this server run
+	errorLogFile := path.Join(logDir, "error.log")
+	errorLogOffset := fileSize(errorLogFile)
 
 	// Start MySQL
-	cmd := prepareCommand(isRoot, path.Join(binPath, "mysqld_safe"),
+	phase = PhaseStart
+	args := []string{
 		fmt.Sprintf("--defaults-file=%s", configFile),
-	)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	// Not in my.cnf: MySQL would also run it during initialization
+	if cfg.initFile != "" || cfg.initSQL != "" {
+		initFile, err := writeInitFile(dir, cfg.initFile, cfg.initSQL)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--init-file=%s", initFile))
+	}
+
+	command := bins.path(serverBinary)
+	var prelude []string
+	if cfg.umask != nil {
+		prelude = append(prelude, umaskPrelude(*cfg.umask))
+	}
+	if cfg.preload != "" {
+		prelude = append(prelude, "export LD_PRELOAD="+shellQuote(cfg.preload))
+	}
+	command, args = wrapShell(prelude, command, args)
+	command, args = wrapAffinity(cfg.cpuAffinity, command, args)
+
+	mysql := &MySQL{
+		dir:      dir,
+		dataDir:  dataDir,
+		logDir:   logDir,
+		keepData: cfg.keepData,
+
+		serverCommand:   command,
+		serverArgs:      args,
+		startupTimeout:  cfg.startupTimeout,
+		shutdownTimeout: cfg.shutdownTimeout,
+
+		isRoot:    isRoot,
+		isMariaDB: isMariaDB,
+		version:   serverVersion,
+		bins:      bins,
+		sockFile:  sockFile,
+		port:      port,
+		dbName:    cfg.dbName,
+		dsnParams: cfg.dsnParams(),
+
+		errorLogFile: errorLogFile,
+		readyLine:    cfg.logsReadyLine(isMariaDB, serverVersion),
+		initOutput:   initOutput,
+		auditLogFile: auditLogFile,
+		pluginDir:    pluginDir,
+
+		users:     make(map[string]string),
+		databases: make(map[string]*sql.DB),
+	}
+	if initialized {
+		// Set by the instance that created the data directory
+		mysql.rootPassword = cfg.rootPassword
+	}
+
+	err = mysql.launch()
 	if err != nil {
-		stderr.Close()
 		return nil, err
 	}
+	cfg.logf("Started server, pid %d", mysql.cmd.Process.Pid)
 
-	err = cmd.Start()
+	rootDB, err := mysql.waitReady(ctx, errorLogOffset)
 	if err != nil {
-		return nil, abort("Failed to start database", cmd, stderr, stdout, err)
+		if cfg.onReadyTimeout != nil {
+			cfg.onReadyTimeout(mysql.readyTimeoutReport())
+		}
+		err = fmt.Errorf("%w\nERROR LOG: %s%s", err, mysql.errorLogTail(20), cfg.customHint())
+		return nil, mysql.abort(PhaseConnect, "Failed to connect to test DB", err)
 	}
+	defer rootDB.Close()
+	cfg.logf("Connected to server")
 
-	mysql := &MySQL{
-		cmd: cmd,
-		dir: dir,
+	if cfg.systemInit != nil {
+		err = mysql.runSystemInit(cfg.systemInit)
+		if err != nil {
+			return nil, mysql.abort(PhaseSetup, "Failed to run system init", err)
+		}
+	}
 
-		stderr: stderr,
-		stdout: stdout,
+	_, err = rootDB.Exec("CREATE DATABASE IF NOT EXISTS " + quoteIdent(mysql.dbName))
+	if err != nil {
+		return nil, mysql.abort(PhaseSetup, "Failed to create test DB", err)
+	}
 
-		isRoot:   isRoot,
-		binPath:  binPath,
-		sockFile: sockFile,
+	for _, name := range cfg.databases {
+		_, err = rootDB.Exec("CREATE DATABASE IF NOT EXISTS " + quoteIdent(name))
+		if err != nil {
+			return nil, mysql.abort(PhaseSetup, "Failed to create database "+name, err)
+		}
+		mysql.databases[name] = nil
 	}
 
-	// Connect to DB, waiting for it to start
-	err = retry(func() error {
-		dsn := makeDSN(sockFile, "test")
-		db, err := sql.Open("mysql", dsn)
+	if cfg.rootPassword != "" {
+		_, err = rootDB.Exec(setPasswordStatement(isMariaDB, serverVersion, "root", cfg.rootPassword))
 		if err != nil {
-			return err
+			return nil, mysql.abort(PhaseSetup, "Failed to set root password", err)
 		}
+		mysql.rootPassword = cfg.rootPassword
+	}
 
-		err = db.Ping()
+	for _, u := range cfg.users {
+		err = createUser(rootDB, mysql.dbName, u)
 		if err != nil {
-			return err
+			return nil, mysql.abort(PhaseSetup, "Failed to create user "+u.name, err)
 		}
+		mysql.users[u.name] = u.password
+	}
 
-		mysql.DB = db
-		return nil
-	}, 1000, 10*time.Millisecond)
+	mysql.DB, err = sql.Open("mysql", mysql.dsn("root", mysql.dbName))
+	if err == nil {
+		err = mysql.DB.Ping()
+	}
 	if err != nil {
-		return nil, abort("Failed to connect to test DB", cmd, stderr, stdout, err)
+		return nil, mysql.abort(PhaseSetup, "Failed to connect to test DB", err)
+	}
+
+	for _, schema := range cfg.schemas {
+		if schema.file != "" {
+			err = mysql.ExecFile(schema.file)
+		} else {
+			err = mysql.execStatements(schema.sql)
+		}
+		if err != nil {
+			return nil, mysql.abort(PhaseSetup, "Failed to load schema", err)
+		}
+	}
+
+	for _, seed := range cfg.seeds {
+		err = seed(mysql.DB)
+		if err != nil {
+			return nil, mysql.abort(PhaseSetup, "Failed to seed", err)
+		}
+	}
+
+	phase = PhaseSetup
+	if cfg.dsnFile != "" {
+		err = mysql.writeDSNFile(cfg.dsnFile)
+		if err != nil {
+			return nil, mysql.abort(PhaseSetup, "Failed to write DSN file", err)
+		}
 	}
 
+	cfg.logf("Ready")
+	started = true
 	return mysql, nil
 }
 
-// Stop the database and remove storage files.
+// Stop the database and remove storage files (unless WithKeepData was
+// used).
 func (p *MySQL) Stop() error {
+	return p.StopContext(context.Background())
+}
+
+// Stop the database like Stop, but kill it when it hasn't shut down by the
+// time the context is done. Storage files are removed either way.
+func (p *MySQL) StopContext(ctx context.Context) error {
 	if p == nil {
 		return nil
 	}
 
 	defer func() {
+		p.closeDatabases()
+
 		// Always try to remove it
-		os.RemoveAll(p.dir)
+		if !p.keepData {
+			os.RemoveAll(p.dir)
+			os.RemoveAll(p.logDir)
+		}
+		for _, snapshot := range p.snapshots {
+			os.RemoveAll(snapshot)
+		}
+		release("socket " + p.sockFile)
+		if p.port != 0 {
+			release(fmt.Sprintf("port %d", p.port))
+		}
 	}()
 
+	// Never started or already gone
+	if p.cmd == nil || p.killed {
+		return nil
+	}
+
+	return p.shutdown(ctx)
+}
+
+// Start the server process, see waitReady to wait for it.
+func (p *MySQL) launch() error {
+	cmd := prepareCommand(p.isRoot, p.serverCommand, p.serverArgs...)
+
+	// So killProcesses reaches mysqld before its PID is known
+	setProcessGroup(cmd)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stderr.Close()
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return &StartError{Phase: PhaseStart, Err: err, msg: "Failed to start database"}
+	}
+
+	if p.output == nil {
+		p.output = newRingBuffer(serverLogSize)
+	}
+	p.outputDone = make(chan struct{})
+	captureOutput(p.output, p.outputDone, stdout, stderr)
+
+	p.cmd = cmd
+	p.killed = false
+	return nil
+}
+
+// Connect to the server as root, waiting for it to start. Only log lines
+// after errorLogOffset count, those before are from earlier runs.
+func (p *MySQL) waitReady(ctx context.Context, errorLogOffset int64) (*sql.DB, error) {
+	var rootDB *sql.DB
+	interval := 10 * time.Millisecond
+	attempts := int(p.startupTimeout / interval)
+	err := retry(ctx, func() error {
+		dsn := p.dsn("root", "")
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return err
+		}
+
+		err = db.Ping()
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		// Ping can succeed while InnoDB is still recovering
+		if p.readyLine {
+			err = checkReady(p.errorLogFile, errorLogOffset)
+			if err != nil {
+				db.Close()
+				return err
+			}
+		}
+
+		rootDB = db
+		return nil
+	}, attempts, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	p.serverPID = readServerPID(rootDB)
+	return rootDB, nil
+}
+
+// Shut the server down cleanly, killing it when that takes until the context
+// is done.
+func (p *MySQL) shutdown(ctx context.Context) error {
 	// mysqladmin -u root -S /tmp/mysqltest810067242/sock/mysql.sock shutdown
-	shutdown := prepareCommand(p.isRoot, path.Join(p.binPath, "mysqladmin"),
-		"-u", "root",
-		"-S", p.sockFile,
-		"shutdown",
-	)
-	out, err := shutdown.CombinedOutput()
+	shutdown := prepareCommand(p.isRoot, p.bins.path("mysqladmin"), append(p.clientArgs(), "shutdown")...)
+	out, err := combinedOutputTimeout(ctx, shutdown, p.shutdownTimeout)
+	if ctx.Err() != nil {
+		return p.killAfter(ctx.Err())
+	}
+	if errors.Is(err, errTimedOut) {
+		return p.killAfter(fmt.Errorf("Graceful shutdown timed out: %w -> %s", err, string(out)))
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to shutdown DB: %w -> %s\nERROR LOG: %s", err, string(out), p.errorLogTail(20))
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		p.drainOutput(time.Second)
+		waitErr <- p.cmd.Wait()
+	}()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		p.killProcesses()
+		<-waitErr
+		return p.killAfter(ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("Server exited with error: %w\nERROR LOG: %s", err, p.errorLogTail(20))
+	}
+
+	// mysqld_safe can exit slightly before mysqld itself is gone
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return p.WaitStopped(waitCtx)
+}
+
+// Kill the server with SIGKILL, without a clean shutdown, e.g. to test crash
+// recovery. The data directory is left intact, call Stop afterwards to remove
+// it.
+func (p *MySQL) Kill() error {
+	if p == nil || p.cmd == nil || p.killed {
+		return nil
+	}
+
+	// Not ready yet when Start gives up, mysqld might have written it already.
+	// The file could be left by an earlier server on the same data directory.
+	if p.serverPID == 0 {
+		pid := readPIDFile(p.dataDir)
+		if pid != 0 && inProcessGroup(pid, p.cmd.Process.Pid) {
+			p.serverPID = pid
+		}
+	}
+
+	err := p.killProcesses()
+	if err != nil {
+		return err
+	}
+
+	p.drainOutput(time.Second)
+	p.cmd.Wait()
+	p.killed = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return p.WaitStopped(ctx)
+}
+
+// SIGKILL mysqld and its mysqld_safe wrapper, without waiting for them. Both
+// are in the process group started by launch.
+func (p *MySQL) killProcesses() error {
+	// mysqld_safe restarts mysqld when it crashes, so kill it first
+	if p.serverPID != 0 {
+		ppid := parentPID(p.serverPID)
+		if ppid > 1 && ppid != os.Getpid() {
+			killPID(ppid)
+		}
+
+		err := killPID(p.serverPID)
+		if err != nil {
+			return fmt.Errorf("Failed to kill server: %w", err)
+		}
+	}
+
+	killProcessGroup(p.cmd)
+	return nil
+}
+
+// Kill the server when a graceful shutdown took too long, err says why.
+func (p *MySQL) killAfter(err error) error {
+	if !p.killed {
+		p.Kill()
+	}
+	return fmt.Errorf("Failed to shutdown DB, killed it: %w", err)
+}
+
+// Process ID of mysqld itself (not the mysqld_safe wrapper), e.g. to attach a
+// profiler. Zero when it couldn't be determined.
+func (p *MySQL) PID() int {
+	return p.serverPID
+}
+
+// Send a signal to mysqld, e.g. SIGHUP to make it flush logs and caches.
+func (p *MySQL) Signal(sig os.Signal) error {
+	if p.serverPID == 0 {
+		return fmt.Errorf("Server PID is unknown")
+	}
+
+	proc, err := os.FindProcess(p.serverPID)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+// Block until the mysqld process has exited and released its files.
+//
+// Stop already does this, it's mostly useful after shutting the server down
+// by other means.
+func (p *MySQL) WaitStopped(ctx context.Context) error {
+	pid := p.serverPID
+	alive := processAlive
+	if pid == 0 {
+		if p.cmd == nil {
+			return nil
+		}
+
+		// PID unknown, wait for everything launch started instead
+		pid = p.cmd.Process.Pid
+		alive = processGroupAlive
+	}
+
+	for alive(pid) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Server process %d still running: %w", pid, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Directory the plugins passed to WithPlugins were copied to, empty when
+// using the server's own plugin directory.
+func (p *MySQL) PluginDir() string {
+	return p.pluginDir
+}
+
+// The MySQL data directory.
+func (p *MySQL) DataDir() string {
+	return p.dataDir
+}
+
+// DSN of the test database, as used for DB. Can be used to open additional
+// connection pools or handed to external tools.
+func (p *MySQL) DSN() string {
+	return p.dsn("root", p.dbName)
+}
+
+// DSN of the test database for a user created with WithUser (or root).
+func (p *MySQL) UserDSN(name string) string {
+	return p.dsn(name, p.dbName)
+}
+
+// Path of the server's unix socket.
+func (p *MySQL) Socket() string {
+	return p.sockFile
+}
+
+// Directory holding the general, error and slow query logs.
+func (p *MySQL) LogDir() string {
+	return p.logDir
+}
+
+// Combined output of the data directory initialization step.
+//
+// Useful to check that a configuration doesn't trigger warnings (e.g.
+// deprecated options) during initialization.
+func (p *MySQL) InitOutput() string {
+	return p.initOutput
+}
+
+// The structured components of the connection used for DB, for building
+// connection strings in other formats (e.g. JDBC).
+//
+// Params contains the user (and password, see WithRootPassword) and
+// database, along with the connection charset or collation when set.
+func (p *MySQL) ConnectionParams() (network, addr string, params url.Values) {
+	params = url.Values{}
+	for k, v := range p.dsnParams {
+		params[k] = v
+	}
+	params.Set("user", "root")
+	if p.rootPassword != "" {
+		params.Set("password", p.rootPassword)
+	}
+	params.Set("database", p.dbName)
+	network, addr = p.address()
+	return network, addr, params
+}
+
+// TCP port the server listens on, 0 unless WithTCP was used.
+func (p *MySQL) Port() int {
+	return p.port
+}
+
+// Connection to one of the databases created with WithDatabases (or the test
+// database itself). It's closed by Stop.
+func (p *MySQL) DBFor(name string) (*sql.DB, error) {
+	if name == p.dbName {
+		return p.DB, nil
+	}
+
+	p.databasesMu.Lock()
+	defer p.databasesMu.Unlock()
+
+	db, ok := p.databases[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown database: %s, use WithDatabases to create it", name)
+	}
+	if db != nil {
+		return db, nil
+	}
+
+	db, err := sql.Open("mysql", p.dsn("root", name))
+	if err != nil {
+		return nil, err
+	}
+	p.databases[name] = db
+	return db, nil
+}
+
+func (p *MySQL) closeDatabases() {
+	p.databasesMu.Lock()
+	defer p.databasesMu.Unlock()
+
+	for name, db := range p.databases {
+		if db != nil {
+			db.Close()
+			p.databases[name] = nil
+		}
+	}
+	if p.DB != nil {
+		p.DB.Close()
+	}
+}
+
+// Open a connection to the test database as a user that is only granted
+// SELECT privileges. Code-under-test that receives this handle can't write.
+//
+// The user (mysqltest_ro) is created on first use, without a password. Like
+// users created with WithUser, it can only log in from localhost, which
+// includes TCP connections when using WithTCP. The caller should close the
+// returned DB.
+func (p *MySQL) ReadOnlyDB() (*sql.DB, error) {
+	_, err := p.DB.Exec(fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'localhost'", quoteString(readOnlyUser)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create read-only user: %w", err)
+	}
+
+	_, err = p.DB.Exec(fmt.Sprintf("GRANT SELECT ON %s.* TO %s@'localhost'", quoteIdent(p.dbName), quoteString(readOnlyUser)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to grant read-only user: %w", err)
+	}
+
+	db, err := sql.Open("mysql", p.dsn(readOnlyUser, p.dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Run fn with a root connection to the mysql system database.
+func (p *MySQL) runSystemInit(fn func(*sql.DB) error) error {
+	db, err := sql.Open("mysql", p.dsn("root", "mysql"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(db)
+}
+
+// Drop and recreate the test database, reconnecting DB. Much quicker than
+// starting a new server to get a clean slate between tests.
+//
+// Handles obtained from DB before the reset are closed.
+func (p *MySQL) Reset() error {
+	_, err := p.DB.Exec("DROP DATABASE IF EXISTS " + quoteIdent(p.dbName))
+	if err != nil {
+		return fmt.Errorf("Failed to drop database: %w", err)
+	}
+
+	_, err = p.DB.Exec("CREATE DATABASE " + quoteIdent(p.dbName))
+	if err != nil {
+		return fmt.Errorf("Failed to create database: %w", err)
+	}
+
+	// Pooled connections still refer to the dropped database
+	p.DB.Close()
+	db, err := sql.Open("mysql", p.dsn("root", p.dbName))
+	if err != nil {
+		return err
+	}
+	p.DB = db
+	return db.Ping()
+}
+
+// Find the mysqld PID through its pid file, 0 if unknown.
+func readServerPID(db *sql.DB) int {
+	var pidFile string
+	err := db.QueryRow("SELECT @@global.pid_file").Scan(&pidFile)
+	if err != nil {
+		return 0
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Find the mysqld PID through the pid file in the data directory (named after
+// the host), 0 if there is none yet.
+func readPIDFile(dataDir string) int {
+	files, err := filepath.Glob(path.Join(dataDir, "*.pid"))
 	if err != nil {
-		return fmt.Errorf("Failed to shutdown DB: %w -> %s", err, string(out))
+		return 0
 	}
 
-	err = p.cmd.Wait()
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid > 0 {
+			return pid
+		}
+	}
+	return 0
+}
+
+// Run the command through taskset to pin it to the given CPUs. Only
+// supported on Linux, returns the command unchanged elsewhere or when taskset
+// isn't installed.
+func wrapAffinity(cpus []int, command string, args []string) (string, []string) {
+	if len(cpus) == 0 || runtime.GOOS != "linux" {
+		return command, args
+	}
+
+	taskset, err := exec.LookPath("taskset")
+	if err != nil {
+		return command, args
+	}
+
+	list := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		list[i] = strconv.Itoa(cpu)
+	}
+	return taskset, append([]string{"-c", strings.Join(list, ","), command}, args...)
+}
+
+// Run the command through a shell that executes the prelude first, e.g. to
+// set the umask or environment. Environment variables can't be set on the
+// command itself, prepareCommand replaces them when running as root.
+func wrapShell(prelude []string, command string, args []string) (string, []string) {
+	if len(prelude) == 0 || runtime.GOOS == "windows" {
+		return command, args
+	}
+
+	script := strings.Join(prelude, " && ") + ` && exec "$0" "$@"`
+	return "sh", append([]string{"-c", script, command}, args...)
+}
+
+// Sets the umask, along with the UMASK / UMASK_DIR creation modes mysqld uses
+// for the files it creates.
+func umaskPrelude(umask os.FileMode) string {
+	m := umask & os.ModePerm
+	return fmt.Sprintf("umask %04o && export UMASK=%04o UMASK_DIR=%04o", m, 0666&^m, 0777&^m)
+}
+
+// Combine the init file and inline SQL into a file the server can read.
+func writeInitFile(dir, filename, inline string) (string, error) {
+	var content []byte
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read init file: %w", err)
+		}
+		content = append(data, '\n')
+	}
+	content = append(content, inline...)
+
+	initFile := path.Join(dir, "init.sql")
+	err := ioutil.WriteFile(initFile, content, 0644)
+	if err != nil {
+		return "", err
+	}
+	return initFile, nil
+}
+
+// Describe the server state when it failed to become ready.
+func (p *MySQL) readyTimeoutReport() string {
+	state := "has exited"
+	if processAlive(p.cmd.Process.Pid) {
+		state = "is still running (possibly still initializing)"
+	}
+
+	errorLog, err := ioutil.ReadFile(p.errorLogFile)
+	if err != nil {
+		errorLog = []byte(fmt.Sprintf("(unavailable: %s)", err))
+	}
+	return fmt.Sprintf("Server process %d %s\nERROR LOG:\n%s", p.cmd.Process.Pid, state, errorLog)
+}
+
+// Contents of the server's error log.
+func (p *MySQL) ErrorLog() (string, error) {
+	data, err := ioutil.ReadFile(p.errorLogFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read error log: %w", err)
+	}
+	return string(data), nil
+}
+
+// The last lines of the error log, e.g. to find out why the server crashed.
+func (p *MySQL) errorLogTail(lines int) string {
+	data, err := ioutil.ReadFile(p.errorLogFile)
+	if err != nil {
+		return fmt.Sprintf("(unavailable: %s)", err)
+	}
+
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
+
+// Whether a data directory was initialized before, e.g. by an earlier
+// instance that used WithKeepData.
+func isInitialized(dataDir string) bool {
+	fi, err := os.Stat(path.Join(dataDir, "mysql"))
+	return err == nil && fi.IsDir()
+}
+
+func fileSize(filename string) int64 {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Check whether the server logged that it finished starting up, after the
+// given offset in the error log. Servers that don't write an error log are
+// considered ready.
+func checkReady(errorLogFile string, offset int64) error {
+	f, err := os.Open(errorLogFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	if p.stderr != nil {
-		p.stderr.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
 	}
 
-	if p.stdout != nil {
-		p.stdout.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
 	}
 
+	if !strings.Contains(string(data), "ready for connections") {
+		return fmt.Errorf("Server is still starting up")
+	}
 	return nil
 }
 
-// Needed because Ubuntu doesn't put initdb in $PATH
-func findBinPath() (string, error) {
-	// In $PATH (e.g. Fedora) great!
-	p, err := exec.LookPath("mysqld_safe")
-	if err == nil {
-		return path.Dir(p), nil
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	return "", fmt.Errorf("Did not find MySQL / MariaDB executables installed")
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Write the connection info as KEY=value lines, which can be sourced by a
+// shell.
+func (p *MySQL) writeDSNFile(filename string) error {
+	content := fmt.Sprintf("MYSQL_DSN=%s\nMYSQL_SOCKET=%s\nMYSQL_DATABASE=%s\n",
+		shellQuote(p.dsn("root", p.dbName)),
+		shellQuote(p.sockFile),
+		shellQuote(p.dbName),
+	)
+	if p.port != 0 {
+		content += fmt.Sprintf("MYSQL_HOST=127.0.0.1\nMYSQL_PORT=%d\n", p.port)
+	}
+	// The DSN holds the root password, when there is one
+	return ioutil.WriteFile(filename, []byte(content), 0600)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Whether a MySQL or MariaDB installation is available to Start, e.g. to skip
+// tests when it isn't:
+//
+//	if !mysqltest.Available() {
+//		t.Skip("MySQL is not installed")
+//	}
+func Available() bool {
+	bins, err := findBinaries("")
+	if err != nil {
+		return false
+	}
+
+	version := exec.Command(bins.path("mysql"), "--version")
+	_, err = combinedOutputTimeout(context.Background(), version, 5*time.Second)
+	return err == nil
+}
+
+func (p *MySQL) dsn(user, dbname string) string {
+	password := p.users[user]
+	if user == "root" {
+		password = p.rootPassword
+	}
+	if password != "" {
+		user += ":" + password
+	}
+	network, addr := p.address()
+	return makeDSN(user, network, addr, dbname, p.dsnParams)
+}
+
+// Where clients connect: TCP when enabled, the unix socket otherwise.
+func (p *MySQL) address() (network, addr string) {
+	if p.port != 0 {
+		return "tcp", fmt.Sprintf("127.0.0.1:%d", p.port)
+	}
+	return "unix", p.sockFile
+}
+
+func makeDSN(user, network, addr, dbname string, params url.Values) string {
+	dsn := fmt.Sprintf("%s@%s(%s)/%s", user, network, addr, dbname)
+	if len(params) > 0 {
+		dsn += "?" + params.Encode()
+	}
+	return dsn
+}
+
+// Create a user (when missing) with the given grants on the test database.
+func createUser(db *sql.DB, dbName string, u userSpec) error {
+	_, err := db.Exec(fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'localhost' IDENTIFIED BY %s", quoteString(u.name), quoteString(u.password)))
+	if err != nil {
+		return err
+	}
+
+	grants := "ALL PRIVILEGES"
+	if len(u.grants) > 0 {
+		grants = strings.Join(u.grants, ", ")
+	}
+	_, err = db.Exec(fmt.Sprintf("GRANT %s ON %s.* TO %s@'localhost'", grants, quoteIdent(dbName), quoteString(u.name)))
+	return err
 }
 
-func makeDSN(sockDir, dbname string) string {
-	return fmt.Sprintf("root@unix(%s)/%s", sockDir, dbname)
+// ALTER USER only exists since MariaDB 10.2, which still accepts SET PASSWORD.
+func setPasswordStatement(isMariaDB bool, v Version, user, password string) string {
+	if isMariaDB && !v.AtLeast(10, 2, 0) {
+		return fmt.Sprintf("SET PASSWORD FOR %s@'localhost' = PASSWORD(%s)", quoteString(user), quoteString(password))
+	}
+	return fmt.Sprintf("ALTER USER %s@'localhost' IDENTIFIED BY %s", quoteString(user), quoteString(password))
 }
 
-func retry(fn func() error, attempts int, interval time.Duration) error {
+// Find a free port to listen on by letting the kernel pick one. It could be
+// taken again before the server binds it, but that's unlikely.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func retry(ctx context.Context, fn func() error, attempts int, interval time.Duration) error {
 	for {
 		err := fn()
 		if err == nil {
@@ -281,36 +1336,88 @@ func retry(fn func() error, attempts int, interval time.Duration) error {
 			return err
 		}
 
-		time.Sleep(interval)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %s)", ctx.Err(), err)
+		case <-time.After(interval):
+		}
 	}
 }
 
-func prepareCommand(isRoot bool, command string, args ...string) *exec.Cmd {
-	if !isRoot {
-		return exec.Command(command, args...)
+// Returned by combinedOutputTimeout when the command took too long.
+var errTimedOut = errors.New("Timed out")
+
+// Like cmd.CombinedOutput, but kills the command (and everything it spawned)
+// when it takes longer than timeout or the context is done. A zero timeout
+// waits forever.
+func combinedOutputTimeout(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
 	}
 
-	for i, a := range args {
-		if a == "" {
-			args[i] = "''"
+	done := make(chan struct{})
+	var killed int32
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&killed, 1)
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+
+	if atomic.LoadInt32(&killed) == 1 {
+		if parent.Err() != nil {
+			return out.Bytes(), parent.Err()
 		}
+		return out.Bytes(), fmt.Errorf("%w after %s", errTimedOut, timeout)
 	}
+	return out.Bytes(), err
+}
 
-	return exec.Command("su",
-		"-",
-		"mysql",
-		"-c",
-		strings.Join(append([]string{command}, args...), " "),
-	)
+func prepareCommand(isRoot bool, command string, args ...string) *exec.Cmd {
+	cmd := exec.Command(command, args...)
+	if isRoot {
+		dropPrivileges(cmd)
+	}
+	return cmd
 }
 
-func abort(msg string, cmd *exec.Cmd, stderr, stdout io.ReadCloser, err error) error {
-	cmd.Process.Signal(os.Interrupt)
-	cmd.Wait()
+// Stop a server that failed to get set up, its files are removed by Start.
+// It's killed rather than interrupted: mysqld_safe ignores SIGINT while mysqld
+// runs, and the data is thrown away anyway.
+func (p *MySQL) abort(phase Phase, msg string, err error) error {
+	p.closeDatabases()
+	p.Kill()
 
-	serr, _ := ioutil.ReadAll(stderr)
-	sout, _ := ioutil.ReadAll(stdout)
-	stderr.Close()
-	stdout.Close()
-	return fmt.Errorf("%s: %s\nOUT: %s\nERR: %s", msg, err, string(sout), string(serr))
+	return &StartError{
+		Phase:  phase,
+		Output: p.output.String(),
+		Err:    err,
+		msg:    msg,
+	}
+}
+
+func initError(err error, out []byte, cfg *config) error {
+	return &StartError{
+		Phase:  PhaseInit,
+		Output: string(out),
+		Err:    fmt.Errorf("%w%s", err, cfg.customHint()),
+		msg:    "Failed to initialize DB",
+	}
 }
@@ -1,7 +1,18 @@
 package mysqltest_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rubenv/mysqltest"
 	"github.com/stretchr/testify/assert"
@@ -20,3 +31,1053 @@ func TestMySQL(t *testing.T) {
 	err = mysql.Stop()
 	assert.NoError(err)
 }
+
+func TestReadOnlyDB(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	assert.NotNil(mysql)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+
+	db, err := mysql.ReadOnlyDB()
+	assert.NoError(err)
+	defer db.Close()
+
+	_, err = db.Exec("SELECT * FROM test")
+	assert.NoError(err)
+
+	_, err = db.Exec("INSERT INTO test (val) VALUES ('nope')")
+	assert.Error(err)
+}
+
+func TestReadOnlyDBTCP(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithTCP(), mysqltest.WithRootPassword("s3cret"))
+	assert.NoError(err)
+	assert.NotNil(mysql)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+
+	db, err := mysql.ReadOnlyDB()
+	assert.NoError(err)
+	defer db.Close()
+
+	// Logged in without a password, over TCP
+	var user string
+	assert.NoError(db.QueryRow("SELECT CURRENT_USER()").Scan(&user))
+	assert.Equal("mysqltest_ro@localhost", user)
+
+	_, err = db.Exec("SELECT * FROM test")
+	assert.NoError(err)
+
+	_, err = db.Exec("INSERT INTO test (val) VALUES ('nope')")
+	assert.Error(err)
+}
+
+func TestExplain(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	assert.NotNil(mysql)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (id int primary key, val text)")
+	assert.NoError(err)
+
+	_, err = mysql.DB.Exec("INSERT INTO test (id, val) VALUES (1, 'a'), (2, 'b')")
+	assert.NoError(err)
+
+	plan, err := mysql.Explain("SELECT val FROM test WHERE id = ?", 1)
+	assert.NoError(err)
+	assert.NotEmpty(plan.JSON)
+
+	tables := plan.Tables()
+	if assert.Len(tables, 1) {
+		assert.Equal("test", tables[0].Name)
+		assert.Equal("PRIMARY", tables[0].Key)
+	}
+}
+
+func TestPool(t *testing.T) {
+	assert := assert.New(t)
+
+	pool, err := mysqltest.NewPool(1)
+	assert.NoError(err)
+	defer pool.Close()
+
+	mysql, err := pool.Get()
+	assert.NoError(err)
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+	pool.Put(mysql)
+
+	// Same instance, but with a clean database
+	again, err := pool.Get()
+	assert.NoError(err)
+	assert.Equal(mysql, again)
+
+	_, err = again.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+	pool.Put(again)
+}
+
+func TestDistinctSockets(t *testing.T) {
+	assert := assert.New(t)
+
+	pool, err := mysqltest.NewPool(2)
+	assert.NoError(err)
+	defer pool.Close()
+
+	a, err := pool.Get()
+	assert.NoError(err)
+	b, err := pool.Get()
+	assert.NoError(err)
+
+	_, addrA, _ := a.ConnectionParams()
+	_, addrB, _ := b.ConnectionParams()
+	assert.NotEqual(addrA, addrB)
+}
+
+func TestForTest(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {
+			db := mysql.ForTest(t)
+
+			// Each test gets an empty database
+			_, err := db.Exec("CREATE TABLE test (val text)")
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestStartT(t *testing.T) {
+	assert := assert.New(t)
+
+	var sock string
+	t.Run("start", func(t *testing.T) {
+		mysql := mysqltest.StartT(t)
+		sock = mysql.Socket()
+		assert.NoError(mysql.DB.Ping())
+	})
+
+	// Stopped when the subtest finished
+	_, err := os.Stat(sock)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestTCP(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithTCP())
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	assert.NotZero(mysql.Port())
+	network, _, _ := mysql.ConnectionParams()
+	assert.Equal("tcp", network)
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+}
+
+func TestStartReplicationFrom(t *testing.T) {
+	assert := assert.New(t)
+
+	src, err := mysqltest.StartWithOptions(mysqltest.WithTCP(), mysqltest.WithGTID())
+	assert.NoError(err)
+	defer src.Stop()
+
+	replica, err := mysqltest.StartWithOptions(mysqltest.WithGTID())
+	assert.NoError(err)
+	defer replica.Stop()
+
+	// Skip the source's setup, the replica has its own test database
+	gtids, err := src.GTIDExecuted()
+	assert.NoError(err)
+
+	_, err = src.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+
+	assert.NoError(replica.StartReplicationFrom(src, gtids))
+
+	var n int
+	for i := 0; i < 50; i++ {
+		err = replica.DB.QueryRow("SELECT COUNT(*) FROM test").Scan(&n)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(err)
+}
+
+func TestStartContextCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mysql, err := mysqltest.StartContext(ctx)
+	assert.Error(err)
+	assert.Nil(mysql)
+}
+
+func TestReset(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+
+	err = mysql.Reset()
+	assert.NoError(err)
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+}
+
+func TestSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithSchema(`
+		CREATE TABLE a (val text);
+		CREATE TABLE b (val text);
+	`))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("INSERT INTO b (val) VALUES ('x')")
+	assert.NoError(err)
+
+	_, err = mysqltest.StartWithOptions(mysqltest.WithSchema("CREATE TABLE a (val text); CREATE TABLE a (val text);"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "Statement 2 failed")
+}
+
+func TestRootPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithRootPassword("s3cret"))
+	assert.NoError(err)
+
+	assert.Contains(mysql.DSN(), "root:s3cret@")
+	assert.NoError(mysql.DB.Ping())
+
+	// Shutdown has to authenticate
+	assert.NoError(mysql.Stop())
+}
+
+func TestUser(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithUser("app", "pw", "SELECT"))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	db, err := sql.Open("mysql", mysql.UserDSN("app"))
+	assert.NoError(err)
+	defer db.Close()
+
+	assert.NoError(db.Ping())
+	_, err = db.Exec("CREATE TABLE test (val text)")
+	assert.Error(err)
+}
+
+func TestLoadDump(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// A trigger, as mysqldump writes it
+	filename := filepath.Join(dir, "dump.sql.gz")
+	f, err := os.Create(filename)
+	assert.NoError(err)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(`
+CREATE TABLE test (val int);
+DELIMITER ;;
+CREATE TRIGGER double_val BEFORE INSERT ON test FOR EACH ROW BEGIN SET NEW.val = NEW.val * 2; END;;
+DELIMITER ;
+INSERT INTO test VALUES (21);
+`))
+	assert.NoError(gz.Close())
+	assert.NoError(f.Close())
+
+	assert.NoError(mysql.LoadDump(filename))
+
+	var val int
+	assert.NoError(mysql.DB.QueryRow("SELECT val FROM test").Scan(&val))
+	assert.Equal(42, val)
+
+	err = mysql.LoadDump(filename)
+	assert.Error(err)
+	assert.Contains(err.Error(), "already exists")
+}
+
+func TestDumpTo(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithSchema(`
+		CREATE TABLE test (val text);
+		INSERT INTO test VALUES ('exported');
+	`))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	var buf bytes.Buffer
+	assert.NoError(mysql.DumpTo(&buf))
+	assert.Contains(buf.String(), "CREATE TABLE `test`")
+	assert.Contains(buf.String(), "exported")
+
+	buf.Reset()
+	assert.NoError(mysql.DumpTo(&buf, "--no-data"))
+	assert.Contains(buf.String(), "CREATE TABLE `test`")
+	assert.NotContains(buf.String(), "exported")
+
+	err = mysql.DumpTo(&buf, "--no-such-flag")
+	assert.Error(err)
+	assert.Contains(err.Error(), "no-such-flag")
+}
+
+func TestFastInsecure(t *testing.T) {
+	assert := assert.New(t)
+
+	insertRows := func(opts ...mysqltest.Option) time.Duration {
+		mysql, err := mysqltest.StartWithOptions(append(opts, mysqltest.WithSchema("CREATE TABLE test (val int)"))...)
+		assert.NoError(err)
+		defer mysql.Stop()
+
+		// Autocommit, so every insert is a separate (synced) commit
+		start := time.Now()
+		for i := 0; i < 500; i++ {
+			_, err := mysql.DB.Exec("INSERT INTO test VALUES (?)", i)
+			assert.NoError(err)
+		}
+		return time.Since(start)
+	}
+
+	safe := insertRows()
+	fast := insertRows(mysqltest.WithFastInsecure())
+	t.Logf("500 inserts: %s durable, %s with WithFastInsecure (%.1fx faster)", safe, fast, float64(safe)/float64(fast))
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithFastInsecure())
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	var flush int
+	assert.NoError(mysql.DB.QueryRow("SELECT @@innodb_flush_log_at_trx_commit").Scan(&flush))
+	assert.Equal(0, flush)
+}
+
+func TestDatabases(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithDatabases("app", "analytics"))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	for _, name := range []string{"app", "analytics"} {
+		db, err := mysql.DBFor(name)
+		assert.NoError(err)
+
+		var current string
+		assert.NoError(db.QueryRow("SELECT DATABASE()").Scan(&current))
+		assert.Equal(name, current)
+	}
+
+	_, err = mysql.DBFor("missing")
+	assert.Error(err)
+}
+
+func TestConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithConfig(map[string]string{
+		"max_connections": "123",
+		"general_log":     "0",
+	}))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	var maxConnections, generalLog int
+	assert.NoError(mysql.DB.QueryRow("SELECT @@max_connections, @@general_log").Scan(&maxConnections, &generalLog))
+	assert.Equal(123, maxConnections)
+	assert.Equal(0, generalLog)
+}
+
+func TestLogErrorVerbosity(t *testing.T) {
+	assert := assert.New(t)
+
+	// MySQL 5.7 leaves the ready line out of the error log at this level
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithLogErrorVerbosity(1))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	assert.NoError(mysql.DB.Ping())
+
+	// Same through WithConfig, loose- as MariaDB doesn't know the setting
+	custom, err := mysqltest.StartWithOptions(mysqltest.WithConfig(map[string]string{
+		"loose-log-error-verbosity": "1",
+	}))
+	assert.NoError(err)
+	defer custom.Stop()
+
+	assert.NoError(custom.DB.Ping())
+}
+
+func TestCharset(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithCharset("utf8mb4", "utf8mb4_unicode_ci"))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	var charset, collation string
+	assert.NoError(mysql.DB.QueryRow("SELECT @@character_set_server, @@collation_server").Scan(&charset, &collation))
+	assert.Equal("utf8mb4", charset)
+	assert.Equal("utf8mb4_unicode_ci", collation)
+
+	_, err = mysqltest.StartWithOptions(mysqltest.WithCharset("utf8mb4", "no_such_collation"))
+	assert.Error(err)
+}
+
+func TestStartError(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := mysqltest.StartWithOptions(mysqltest.WithSchema("NOT SQL"))
+	var serr *mysqltest.StartError
+	assert.True(errors.As(err, &serr))
+	assert.Equal(mysqltest.PhaseSetup, serr.Phase)
+}
+
+func TestBinPathMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "mysqltest")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, err = mysqltest.StartWithOptions(mysqltest.WithBinPath(dir))
+	var serr *mysqltest.StartError
+	assert.True(errors.As(err, &serr))
+	assert.Equal(mysqltest.PhaseFindBinary, serr.Phase)
+}
+
+func TestBaseDirMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := mysqltest.StartWithOptions(mysqltest.WithBaseDir("/does/not/exist"))
+	assert.EqualError(err, "Invalid base directory: stat /does/not/exist: no such file or directory")
+	var serr *mysqltest.StartError
+	assert.True(errors.As(err, &serr))
+	assert.Equal(mysqltest.PhaseConfig, serr.Phase)
+}
+
+func TestMariaDBBinaryNames(t *testing.T) {
+	assert := assert.New(t)
+
+	// As root the scripts would run as the mysql user, which can't get into
+	// the private temp dir
+	if os.Geteuid() == 0 {
+		t.Skip("Fake executables can't run as the mysql user")
+	}
+
+	// Only the new names, reporting a version that is rejected right away
+	dir, err := ioutil.TempDir("", "mysqltest")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mariadbd-safe", "mariadb", "mariadb-admin"} {
+		script := "#!/bin/sh\necho 'mariadb  Ver 15.1 Distrib 10.11.2-MariaDB, for Linux (x86_64)'\n"
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755))
+	}
+
+	_, err = mysqltest.StartWithOptions(mysqltest.WithBinPath(dir), mysqltest.WithMaxVersion("10.6"))
+	assert.True(errors.Is(err, mysqltest.ErrUnsupportedVersion), "%s", err)
+}
+
+func TestWithinTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+
+	mysql.WithinTransaction(t, func(tx *sql.Tx) {
+		_, err := tx.Exec("INSERT INTO test (val) VALUES ('x')")
+		assert.NoError(err)
+	})
+
+	var count int
+	assert.NoError(mysql.DB.QueryRow("SELECT COUNT(*) FROM test").Scan(&count))
+	assert.Equal(0, count)
+}
+
+func TestMaxExecutionTimeRestored(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	// A single pooled connection, so fn gets the one checked afterwards
+	mysql.DB.SetMaxOpenConns(1)
+
+	variable := "@@SESSION.max_execution_time"
+	if mysql.Flavor() == mysqltest.FlavorMariaDB {
+		variable = "@@SESSION.max_statement_time"
+	}
+
+	var before, during, after string
+	assert.NoError(mysql.DB.QueryRow("SELECT " + variable).Scan(&before))
+
+	failed := errors.New("failed")
+	err = mysql.WithMaxExecutionTime(1500, func(conn *sql.Conn) error {
+		assert.NoError(conn.QueryRowContext(context.Background(), "SELECT "+variable).Scan(&during))
+		return failed
+	})
+	assert.Equal(failed, err)
+	assert.NotEqual(before, during)
+
+	assert.NoError(mysql.DB.QueryRow("SELECT " + variable).Scan(&after))
+	assert.Equal(before, after)
+}
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE test (val text)")
+	assert.NoError(err)
+	_, err = mysql.DB.Exec("INSERT INTO test (val) VALUES ('seeded')")
+	assert.NoError(err)
+
+	snapshot, err := mysql.Snapshot()
+	assert.NoError(err)
+
+	_, err = mysql.DB.Exec("DELETE FROM test")
+	assert.NoError(err)
+
+	assert.NoError(mysql.Restore(snapshot))
+
+	var count int
+	assert.NoError(mysql.DB.QueryRow("SELECT COUNT(*) FROM test").Scan(&count))
+	assert.Equal(1, count)
+}
+
+func TestServerLog(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.ServerLog()
+	assert.NoError(err)
+}
+
+func TestHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	assert.NoError(mysql.Healthy(context.Background()))
+
+	assert.NoError(mysql.Kill())
+	assert.True(errors.Is(mysql.Healthy(context.Background()), mysqltest.ErrServerExited))
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "orphans")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	instance := func(file, content string) string {
+		dir, err := ioutil.TempDir(root, "mysqltest")
+		assert.NoError(err)
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+		return dir
+	}
+	dead := instance("owner.pid", "999999999")
+	alive := instance("owner.pid", strconv.Itoa(os.Getpid()))
+	kept := instance("keep", "")
+
+	// Not ours (or a WithLogDir directory), no matter how old
+	unmarked := instance("other", "")
+	old := time.Now().Add(-24 * time.Hour)
+	assert.NoError(os.Chtimes(unmarked, old, old))
+
+	removed, err := mysqltest.CleanupOrphansIn(root)
+	assert.NoError(err)
+	assert.Equal(1, removed)
+
+	_, err = os.Stat(dead)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(alive)
+	assert.NoError(err)
+	_, err = os.Stat(kept)
+	assert.NoError(err)
+	_, err = os.Stat(unmarked)
+	assert.NoError(err)
+}
+
+func TestStopAfterFailedStart(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithBinPath("/nonexistent"))
+	assert.Error(err)
+	assert.NotPanics(func() {
+		assert.NoError(mysql.Stop())
+	})
+}
+
+func TestCachedInit(t *testing.T) {
+	assert := assert.New(t)
+
+	cache, err := ioutil.TempDir("", "cache")
+	assert.NoError(err)
+	defer os.RemoveAll(cache)
+
+	// The second one copies the data directory of the first
+	for i := 0; i < 2; i++ {
+		mysql, err := mysqltest.StartWithOptions(mysqltest.WithCachedInit(cache))
+		assert.NoError(err)
+		assert.NoError(mysql.Stop())
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// mysqladmin can't finish in time, so the server gets killed
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithShutdownTimeout(time.Nanosecond))
+	assert.NoError(err)
+
+	err = mysql.Stop()
+	assert.Error(err)
+	assert.Contains(err.Error(), "killed it")
+	assert.Contains(err.Error(), "Timed out")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(mysql.WaitStopped(ctx))
+}
+
+func TestFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithSchema("CREATE TABLE test (val int)"))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	var before string
+	assert.NoError(mysql.DB.QueryRow("SELECT @@global.innodb_max_dirty_pages_pct").Scan(&before))
+
+	for i := 0; i < 100; i++ {
+		_, err = mysql.DB.Exec("INSERT INTO test VALUES (?)", i)
+		assert.NoError(err)
+	}
+	assert.NoError(mysql.Flush())
+
+	// Back to normal flushing afterwards
+	var after string
+	assert.NoError(mysql.DB.QueryRow("SELECT @@global.innodb_max_dirty_pages_pct").Scan(&after))
+	assert.Equal(before, after)
+}
+
+func TestTruncate(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithSchema(`
+		CREATE TABLE parent (id int AUTO_INCREMENT PRIMARY KEY);
+		CREATE TABLE child (id int AUTO_INCREMENT PRIMARY KEY, parent_id int, FOREIGN KEY (parent_id) REFERENCES parent (id));
+	`))
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	// Counters start over every time, not just the first
+	for i := 0; i < 2; i++ {
+		res, err := mysql.DB.Exec("INSERT INTO parent () VALUES (), (), ()")
+		assert.NoError(err)
+		id, err := res.LastInsertId()
+		assert.NoError(err)
+		assert.Equal(int64(1), id)
+
+		_, err = mysql.DB.Exec("INSERT INTO child (parent_id) VALUES (1)")
+		assert.NoError(err)
+
+		assert.NoError(mysql.Truncate())
+
+		var count int
+		assert.NoError(mysql.DB.QueryRow("SELECT (SELECT COUNT(*) FROM parent) + (SELECT COUNT(*) FROM child)").Scan(&count))
+		assert.Equal(0, count)
+	}
+}
+
+func TestMigrateDir(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.Start()
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	dir, err := ioutil.TempDir("", "migrations")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, sql string) {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(sql), 0644))
+	}
+	write("002_val.up.sql", "ALTER TABLE test ADD val text;")
+	write("001_test.up.sql", "CREATE TABLE test (id int);")
+	write("001_test.down.sql", "DROP TABLE test;")
+
+	applied, err := mysql.MigrateDir(dir)
+	assert.NoError(err)
+	assert.Equal([]string{"001_test.up.sql", "002_val.up.sql"}, applied)
+
+	// Only new files the next time
+	write("003_more.up.sql", "INSERT INTO test VALUES (1, 'x');")
+	applied, err = mysql.MigrateDir(dir)
+	assert.NoError(err)
+	assert.Equal([]string{"003_more.up.sql"}, applied)
+
+	var val string
+	assert.NoError(mysql.DB.QueryRow("SELECT val FROM test").Scan(&val))
+	assert.Equal("x", val)
+}
+
+func TestPurgeBinlogs(t *testing.T) {
+	assert := assert.New(t)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithGTID())
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	for i := 0; i < 3; i++ {
+		_, err = mysql.DB.Exec("FLUSH BINARY LOGS")
+		assert.NoError(err)
+	}
+
+	assert.NoError(mysql.PurgeBinlogs())
+
+	// Only the current one is left
+	rows, err := mysql.DB.Query("SHOW BINARY LOGS")
+	if assert.NoError(err) {
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		assert.NoError(rows.Err())
+		assert.Equal(1, n)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+
+	plain, err := mysqltest.Start()
+	assert.NoError(err)
+	defer plain.Stop()
+
+	_, err = plain.AuditLog()
+	assert.Error(err)
+
+	mysql, err := mysqltest.StartWithOptions(mysqltest.WithAuditLog())
+	assert.NoError(err)
+	defer mysql.Stop()
+
+	_, err = mysql.DB.Exec("CREATE TABLE audited (val text)")
+	assert.NoError(err)
+
+	log, err := mysql.AuditLog()
+	if errors.Is(err, mysqltest.ErrAuditLogUnavailable) {
+		t.Skip(err)
+	}
+
+	// MySQL writes the audit log asynchronously
+	for i := 0; i < 50 && err == nil && !strings.Contains(log, "audited"); i++ {
+		time.Sleep(100 * time.Millisecond)
+		log, err = mysql.AuditLog()
+	}
+	assert.NoError(err)
+	assert.Contains(log, "audited")
+
+	// Written to the log directory
+	_, err = os.Stat(filepath.Join(mysql.LogDir(), "audit.log"))
+	assert.NoError(err)
+}
+
+func TestParseQueryLog(t *testing.T) {
+	mysqlTime := func(nsec int) time.Time {
+		return time.Date(2024, 1, 2, 10, 0, 0, nsec, time.UTC)
+	}
+	mariaTime := func(hour, sec int) time.Time {
+		return time.Date(2024, 1, 2, hour, 0, sec, 0, time.Local)
+	}
+
+	tests := []struct {
+		name string
+		log  string
+		want []mysqltest.LoggedQuery
+	}{
+		{
+			name: "mysql",
+			log: `/usr/sbin/mysqld, Version: 8.0.36 (MySQL Community Server - GPL). started with:
+Tcp port: 0  Unix socket: /tmp/mysqltest1/sock/mysql.sock
+Time                 Id Command    Argument
+2024-01-02T10:00:00.100000Z	    8 Connect	root@localhost on test using Socket
+2024-01-02T10:00:00.200000Z	    8 Query	SELECT 1
+2024-01-02T10:00:00.300000Z	    8 Quit	
+`,
+			want: []mysqltest.LoggedQuery{
+				{Time: mysqlTime(100000000), ConnectionID: 8, Command: "Connect", Argument: "root@localhost on test using Socket"},
+				{Time: mysqlTime(200000000), ConnectionID: 8, Command: "Query", Argument: "SELECT 1"},
+				{Time: mysqlTime(300000000), ConnectionID: 8, Command: "Quit", Argument: ""},
+			},
+		},
+		{
+			name: "mysql multi-line",
+			log: `2024-01-02T10:00:00.100000Z	   12 Query	SELECT *
+FROM test
+WHERE id = 1
+2024-01-02T10:00:00.200000Z	   12 Query	SELECT 2
+`,
+			want: []mysqltest.LoggedQuery{
+				{Time: mysqlTime(100000000), ConnectionID: 12, Command: "Query", Argument: "SELECT *\nFROM test\nWHERE id = 1"},
+				{Time: mysqlTime(200000000), ConnectionID: 12, Command: "Query", Argument: "SELECT 2"},
+			},
+		},
+		{
+			// The time is only written when it changes, hours are padded
+			// with a space
+			name: "mariadb",
+			log: `/usr/sbin/mariadbd, Version: 10.11.6-MariaDB-log (MariaDB Server). started with:
+Tcp port: 0  Unix socket: /tmp/mysqltest1/sock/mysql.sock
+Time		    Id Command	Argument
+240102 10:00:00	     8 Connect	root@localhost on test using Socket
+		     8 Query	SELECT 1
+240102  9:00:01	     9 Query	INSERT INTO test
+VALUES (1),
+  (2)
+		     9 Quit	
+`,
+			want: []mysqltest.LoggedQuery{
+				{Time: mariaTime(10, 0), ConnectionID: 8, Command: "Connect", Argument: "root@localhost on test using Socket"},
+				{Time: mariaTime(10, 0), ConnectionID: 8, Command: "Query", Argument: "SELECT 1"},
+				{Time: mariaTime(9, 1), ConnectionID: 9, Command: "Query", Argument: "INSERT INTO test\nVALUES (1),\n  (2)"},
+				{Time: mariaTime(9, 1), ConnectionID: 9, Command: "Quit", Argument: ""},
+			},
+		},
+		{
+			name: "empty",
+			log:  "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := mysqltest.ParseQueryLog(strings.NewReader(tt.log))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, entries)
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"simple", "SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"no trailing semicolon", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";; SELECT 1;;", []string{"SELECT 1"}},
+		{"single quotes", "SELECT 'a;b'; SELECT 2", []string{"SELECT 'a;b'", "SELECT 2"}},
+		{"double quotes", `SELECT "a;b"; SELECT 2`, []string{`SELECT "a;b"`, "SELECT 2"}},
+		{"escaped quote", `SELECT 'it\'s;' ; SELECT 'it''s;'`, []string{`SELECT 'it\'s;'`, `SELECT 'it''s;'`}},
+		{"backticks", "CREATE TABLE `a;b` (`c;d` int); SELECT 2", []string{"CREATE TABLE `a;b` (`c;d` int)", "SELECT 2"}},
+		{"backslash in backticks", "SELECT 1 AS `a\\`; SELECT 2", []string{"SELECT 1 AS `a\\`", "SELECT 2"}},
+		{"hash comment", "SELECT 1; # one; two\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"dash comment", "SELECT 1; -- one; two\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"not a dash comment", "SELECT 1--1; SELECT 2", []string{"SELECT 1--1", "SELECT 2"}},
+		{"block comment", "SELECT 1 /* one; two */; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"block comment between tokens", "SELECT/*x*/1;SELECT/**/2", []string{"SELECT 1", "SELECT 2"}},
+		{"versioned comment", "/*!40101 SET NAMES utf8mb4 */; SELECT 2", []string{"/*!40101 SET NAMES utf8mb4 */", "SELECT 2"}},
+		{
+			"delimiter",
+			"CREATE TABLE t (v int);\nDELIMITER ;;\nCREATE TRIGGER x BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.v = 1; SET NEW.v = 2; END ;;\nDELIMITER ;\nINSERT INTO t VALUES (1);",
+			[]string{
+				"CREATE TABLE t (v int)",
+				"CREATE TRIGGER x BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.v = 1; SET NEW.v = 2; END",
+				"INSERT INTO t VALUES (1)",
+			},
+		},
+		{
+			"lowercase delimiter",
+			"delimiter //\nCREATE PROCEDURE p() BEGIN SELECT 1; END//\ndelimiter ;\nCALL p()",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT 1; END", "CALL p()"},
+		},
+		{"delimiter in a statement", "SELECT 1 AS delimiter ; SELECT 2", []string{"SELECT 1 AS delimiter", "SELECT 2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mysqltest.SplitStatements(tt.script))
+		})
+	}
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   mysqltest.Version
+	}{
+		{"mysql  Ver 14.14 Distrib 5.7.44, for Linux (x86_64) using  EditLine wrapper", mysqltest.Version{5, 7, 44}},
+		{"mysql  Ver 8.0.36 for Linux on x86_64 (MySQL Community Server - GPL)", mysqltest.Version{8, 0, 36}},
+		{"mysql  Ver 8.4.0 for macos14 on arm64 (Homebrew)", mysqltest.Version{8, 4, 0}},
+		{"mysql  Ver 15.1 Distrib 10.6.16-MariaDB, for debian-linux-gnu (x86_64) using  EditLine wrapper", mysqltest.Version{10, 6, 16}},
+		{"mariadb  Ver 15.1 Distrib 10.11.6-MariaDB, for debian-linux-gnu (x86_64) using  EditLine wrapper", mysqltest.Version{10, 11, 6}},
+		{"mariadb  Ver 15.1 Distrib 11.2.2-MariaDB, for Linux (x86_64) using readline 5.1", mysqltest.Version{11, 2, 2}},
+		{"mariadb from 11.4.2-MariaDB, client 15.2 for debian-linux-gnu (x86_64) using  EditLine wrapper", mysqltest.Version{11, 4, 2}},
+	}
+
+	for _, tt := range tests {
+		v, err := mysqltest.ParseVersionOutput(tt.output + "\n")
+		assert.NoError(t, err, tt.output)
+		assert.Equal(t, tt.want, v, tt.output)
+	}
+
+	_, err := mysqltest.ParseVersionOutput("mysql: command not found")
+	assert.Error(t, err)
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want mysqltest.Version
+	}{
+		{"8", mysqltest.Version{8, 0, 0}},
+		{"8.0", mysqltest.Version{8, 0, 0}},
+		{"10.6.4", mysqltest.Version{10, 6, 4}},
+		{"11.4", mysqltest.Version{11, 4, 0}},
+	}
+
+	for _, tt := range tests {
+		v, err := mysqltest.ParseVersion(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, v, tt.in)
+	}
+
+	for _, in := range []string{"", "v8.0", "8.0.x", "8.0.36-log", "1.2.3.4"} {
+		_, err := mysqltest.ParseVersion(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		min, max    string
+		v           mysqltest.Version
+		unsupported bool
+	}{
+		{"", "", mysqltest.Version{5, 7, 44}, false},
+		{"8.0", "", mysqltest.Version{5, 7, 44}, true},
+		{"8.0", "", mysqltest.Version{8, 0, 0}, false},
+		{"8.0.20", "", mysqltest.Version{8, 0, 19}, true},
+		{"8.0.20", "", mysqltest.Version{8, 0, 20}, false},
+		{"", "8.0", mysqltest.Version{8, 0, 36}, false},
+		{"", "8.0", mysqltest.Version{8, 4, 0}, true},
+		{"", "8", mysqltest.Version{8, 4, 0}, false},
+		{"", "8.0.20", mysqltest.Version{8, 0, 21}, true},
+		{"10.4", "10.6", mysqltest.Version{10, 6, 16}, false},
+		{"10.4", "10.6", mysqltest.Version{10, 3, 39}, true},
+		{"10.4", "10.6", mysqltest.Version{10, 11, 6}, true},
+	}
+
+	for _, tt := range tests {
+		err := mysqltest.CheckVersion(tt.min, tt.max, tt.v)
+		if tt.unsupported {
+			assert.True(t, errors.Is(err, mysqltest.ErrUnsupportedVersion), "%s in [%s, %s]: %v", tt.v, tt.min, tt.max, err)
+		} else {
+			assert.NoError(t, err, "%s in [%s, %s]", tt.v, tt.min, tt.max)
+		}
+	}
+
+	// An invalid range is an error, not an unsupported version
+	err := mysqltest.CheckVersion("eight", "", mysqltest.Version{8, 0, 36})
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, mysqltest.ErrUnsupportedVersion))
+}
+
+func TestQuoteOptionValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"500", "500"},
+		{"/var/lib/mysql-files", "/var/lib/mysql-files"},
+		{"", ""},
+		{"a b", `"a b"`},
+		{"a\tb", "\"a\tb\""},
+		{"a#b", `"a#b"`},
+		{"it's", `"it's"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\data`, `"C:\\data"`},
+		{"a\nb", `"a\nb"`},
+		{"a\r\nskip-grant-tables", `"a\r\nskip-grant-tables"`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, mysqltest.QuoteOptionValue(tt.in), "%q", tt.in)
+	}
+}
+
+func TestConfigManagedSettings(t *testing.T) {
+	for _, key := range []string{
+		"datadir", "socket", "log_error", "log-error", " general-log-file ", "slow_query_log_file",
+		"port", "bind-address", "skip-networking", "server_id", "plugin_dir",
+	} {
+		_, err := mysqltest.StartWithOptions(mysqltest.WithConfig(map[string]string{key: "/tmp/elsewhere"}))
+		var serr *mysqltest.StartError
+		if assert.True(t, errors.As(err, &serr), "%q", key) {
+			assert.Equal(t, mysqltest.PhaseConfig, serr.Phase)
+			assert.Contains(t, err.Error(), "is managed by mysqltest")
+		}
+	}
+}
@@ -0,0 +1,962 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option changes how the MySQL server is configured, see StartWithOptions.
+type Option func(*config)
+
+var databaseNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type config struct {
+	// Extra [mysqld] settings, written after the defaults
+	settings map[string]string
+
+	// Settings from WithConfig, written last so they override everything
+	custom map[string]string
+
+	logger  func(format string, args ...interface{})
+	started time.Time
+
+	// Plugins to load at startup
+	plugins     []string
+	pluginFiles []string
+
+	dbName       string
+	rootPassword string
+	users        []userSpec
+	databases    []string
+	baseDir      string
+	dataDir      string
+	keepData     bool
+	dsnFile      string
+	logDir       string
+	initFile     string
+	initSQL      string
+	auditLog     bool
+	systemInit   func(*sql.DB) error
+	cpuAffinity  []int
+	umask        *os.FileMode
+	binPath      string
+	initCache    string
+	schemas      []schema
+	seeds        []func(*sql.DB) error
+	tcp          bool
+	preload      string
+
+	binlogExpire time.Duration
+	gtid         bool
+	initTimeout  time.Duration
+
+	startupTimeout  time.Duration
+	shutdownTimeout time.Duration
+
+	onReadyTimeout func(logs string)
+
+	maxExecutionTime int
+	logVerbosity     int
+
+	groupCommitDelay time.Duration
+	groupCommitCount int
+
+	minVersion string
+	maxVersion string
+
+	// Connection settings
+	connCharset   string
+	connCollation string
+}
+
+// User created once the server is up, see WithUser.
+type userSpec struct {
+	name     string
+	password string
+	grants   []string
+}
+
+// Schema loaded once the server is up, either from a file or inline SQL.
+type schema struct {
+	file string
+	sql  string
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		custom: make(map[string]string),
+		settings: map[string]string{
+			// Tests shouldn't hang on the (50 seconds / 1 year) defaults
+			"innodb_lock_wait_timeout": "5",
+			"lock_wait_timeout":        "5",
+
+			// Error messages shouldn't depend on the developer's locale
+			"lc_messages": "en_US",
+		},
+		dbName:       "test",
+		binlogExpire: time.Hour,
+
+		startupTimeout:  10 * time.Second,
+		shutdownTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *config) set(key, value string) {
+	c.settings[key] = value
+}
+
+// Log progress of Start, with the time elapsed since it was called.
+func (c *config) logf(format string, args ...interface{}) {
+	if c.logger == nil {
+		return
+	}
+	elapsed := time.Since(c.started).Round(time.Millisecond)
+	c.logger("mysqltest: [%s] "+format, append([]interface{}{elapsed}, args...)...)
+}
+
+// Settings that are spelled differently on MySQL and MariaDB (or between
+// versions). Called once the flavor and version are known.
+func (c *config) setFlavorSettings(isMariaDB bool, version Version) {
+	seconds := fmt.Sprint(int64(c.binlogExpire / time.Second))
+	oldMySQL := !isMariaDB && version != Version{} && !version.AtLeast(8, 0, 1)
+	oldMariaDB := isMariaDB && !version.AtLeast(10, 6, 1)
+	switch {
+	case oldMySQL || oldMariaDB:
+		// Whole days only before MySQL 8.0.1 and MariaDB 10.6.1, which every
+		// MariaDB still understands
+		days := int64((c.binlogExpire + 24*time.Hour - 1) / (24 * time.Hour))
+		c.set("expire_logs_days", fmt.Sprint(days))
+	case version == Version{}:
+		// Unknown version, skip it rather than fail to start
+		c.set("loose-binlog_expire_logs_seconds", seconds)
+	default:
+		c.set("binlog_expire_logs_seconds", seconds)
+	}
+
+	if c.gtid {
+		c.set("log_bin", "binlog")
+		if !isMariaDB {
+			// MariaDB always tracks GTIDs
+			c.set("gtid_mode", "ON")
+			c.set("enforce_gtid_consistency", "ON")
+		}
+	}
+
+	if c.maxExecutionTime > 0 {
+		name, value := maxExecutionTimeVariable(isMariaDB, c.maxExecutionTime)
+		c.set(name, value)
+	}
+
+	if c.logVerbosity > 0 {
+		// MariaDB always logs errors, log_warnings adds warnings and notes
+		if isMariaDB {
+			c.set("log_warnings", fmt.Sprint(c.logVerbosity-1))
+		} else {
+			c.set("log_error_verbosity", fmt.Sprint(c.logVerbosity))
+		}
+	}
+
+	if c.groupCommitDelay > 0 {
+		delay := fmt.Sprint(int64(c.groupCommitDelay / time.Microsecond))
+		count := fmt.Sprint(c.groupCommitCount)
+		if isMariaDB {
+			// A zero count disables the wait altogether on MariaDB
+			if c.groupCommitCount == 0 {
+				count = "4294967295"
+			}
+			c.set("binlog_commit_wait_usec", delay)
+			c.set("binlog_commit_wait_count", count)
+		} else {
+			c.set("binlog_group_commit_sync_delay", delay)
+			c.set("binlog_group_commit_sync_no_delay_count", count)
+		}
+	}
+}
+
+// Whether the server writes "ready for connections" to the error log. MySQL
+// before 8.0 logs it as a note, which a verbosity below 3 leaves out (whether
+// set with WithLogErrorVerbosity or WithConfig). MySQL 8.0 logs it at system
+// level and MariaDB always logs it.
+func (c *config) logsReadyLine(isMariaDB bool, version Version) bool {
+	if isMariaDB || version.AtLeast(8, 0, 0) {
+		return true
+	}
+
+	level := c.logVerbosity
+	for _, key := range []string{"log_error_verbosity", "loose_log_error_verbosity"} {
+		v, ok := c.custom[key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			// The server will tell, don't depend on the log until then
+			return false
+		}
+		level = n
+	}
+	return level == 0 || level == 3
+}
+
+// MariaDB calls it max_statement_time, in seconds
+func maxExecutionTimeVariable(isMariaDB bool, ms int) (string, string) {
+	if isMariaDB {
+		return "max_statement_time", strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+	}
+	return "max_execution_time", strconv.Itoa(ms)
+}
+
+// Driver parameters added to every DSN.
+func (c *config) dsnParams() url.Values {
+	params := url.Values{}
+
+	// The driver's charset parameter runs SET NAMES, which resets the
+	// collation, so only one of them is passed.
+	if c.connCollation != "" {
+		params.Set("collation", c.connCollation)
+	} else if c.connCharset != "" {
+		params.Set("charset", c.connCharset)
+	}
+	return params
+}
+
+// Check that dir is a directory we can create files in.
+func checkWritable(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, ".mysqltest")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Check for settings the detected server doesn't support, which would make it
+// refuse to start.
+func (c *config) validateFlavor(isMariaDB bool, v Version) error {
+	if !isMariaDB || !v.AtLeast(10, 6, 0) {
+		return nil
+	}
+	for _, key := range []string{"innodb_buffer_pool_instances", "innodb_page_cleaners"} {
+		if _, ok := c.settings[key]; ok {
+			return fmt.Errorf("Invalid setting: MariaDB %s doesn't support %s", v, key)
+		}
+	}
+	return nil
+}
+
+// Settings mysqltest writes itself, which WithConfig can't override, with
+// what to use instead.
+var managedSettings = map[string]string{
+	"datadir":             "see WithDataDir",
+	"socket":              "see Socket",
+	"log_error":           "see WithLogDir",
+	"general_log_file":    "see WithLogDir",
+	"slow_query_log_file": "see WithLogDir",
+	"port":                "see WithTCP",
+	"bind_address":        "see WithTCP",
+	"skip_networking":     "see WithTCP",
+	"server_id":           "replication needs a unique one per instance",
+	"plugin_dir":          "see WithPlugins",
+}
+
+// Check for invalid combinations before anything is started.
+func (c *config) validate() error {
+	for _, name := range append([]string{c.dbName}, c.databases...) {
+		if !databaseNameRe.MatchString(name) {
+			return fmt.Errorf("Invalid database name: %q, must match %s", name, databaseNameRe)
+		}
+	}
+	for k := range c.custom {
+		if hint, ok := managedSettings[normalizeOption(k)]; ok {
+			return fmt.Errorf("Invalid setting: %s is managed by mysqltest, %s", k, hint)
+		}
+	}
+	if c.baseDir != "" {
+		err := checkWritable(c.baseDir)
+		if err != nil {
+			return fmt.Errorf("Invalid base directory: %w", err)
+		}
+	}
+	if c.logVerbosity < 0 || c.logVerbosity > 3 {
+		return fmt.Errorf("Invalid error log verbosity: %d is not within 1-3", c.logVerbosity)
+	}
+	for _, key := range []string{"innodb_buffer_pool_instances", "innodb_page_cleaners"} {
+		v, ok := c.settings[key]
+		if !ok {
+			continue
+		}
+		err := checkRange(key, v, 1, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if v, ok := c.settings["innodb_default_row_format"]; ok {
+		switch strings.ToUpper(v) {
+		case "DYNAMIC", "COMPACT", "REDUNDANT":
+		default:
+			return fmt.Errorf("Invalid default row format: %s, COMPRESSED can only be set per table", v)
+		}
+	}
+	if v, ok := c.settings["report_port"]; ok {
+		err := checkRange("report_port", v, 1, 65535)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRange(key, value string, min, max int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", key, err)
+	}
+	if n < min || n > max {
+		return fmt.Errorf("Invalid value for %s: %d is not within %d-%d", key, n, min, max)
+	}
+	return nil
+}
+
+// Settings to append to the [mysqld] section, in a stable order.
+func (c *config) mysqldSettings() string {
+	keys := make([]string, 0, len(c.settings))
+	for k := range c.settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, plugin := range c.plugins {
+		fmt.Fprintf(&sb, "plugin_load_add = %s\n", plugin)
+	}
+	for _, k := range keys {
+		if _, ok := c.custom[normalizeOption(k)]; ok {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s = %s\n", k, c.settings[k])
+	}
+
+	keys = keys[:0]
+	for k := range c.custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := c.custom[k]
+		if v == "" {
+			fmt.Fprintf(&sb, "%s\n", k)
+		} else {
+			fmt.Fprintf(&sb, "%s = %s\n", k, quoteOptionValue(v))
+		}
+	}
+	return sb.String()
+}
+
+// Dashes and underscores are interchangeable in option names.
+func normalizeOption(key string) string {
+	return strings.Replace(strings.TrimSpace(key), "-", "_", -1)
+}
+
+// Option file values need quotes when they contain whitespace, comment
+// characters or quotes. Line breaks are escaped, they would end the value.
+func quoteOptionValue(v string) string {
+	if !strings.ContainsAny(v, " \t\r\n#'\"\\") {
+		return v
+	}
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	v = strings.Replace(v, "\r", `\r`, -1)
+	return `"` + v + `"`
+}
+
+// Added to startup errors, a bad custom setting is the likely cause.
+func (c *config) customHint() string {
+	if len(c.custom) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(c.custom))
+	for k := range c.custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]string, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, fmt.Sprintf("%s = %s", k, c.custom[k]))
+	}
+	return "\nSETTINGS (WithConfig, these override the defaults): " + strings.Join(list, ", ")
+}
+
+// Number of regions the InnoDB buffer pool is divided into (1-64).
+//
+// Only has an effect when the buffer pool is at least 1GB. MariaDB 10.6
+// removed this setting, Start returns an error there.
+func WithBufferPoolInstances(n int) Option {
+	return func(c *config) {
+		c.set("innodb_buffer_pool_instances", fmt.Sprint(n))
+	}
+}
+
+// Number of threads flushing dirty pages from the buffer pool (1-64).
+//
+// MySQL caps this at the number of buffer pool instances. MariaDB 10.6
+// removed this setting, Start returns an error there.
+func WithPageCleaners(n int) Option {
+	return func(c *config) {
+		c.set("innodb_page_cleaners", fmt.Sprint(n))
+	}
+}
+
+// Write the connection info to a file once the server is up, as KEY=value
+// lines that can be sourced from a shell (MYSQL_DSN, MYSQL_SOCKET and
+// MYSQL_DATABASE, plus MYSQL_HOST and MYSQL_PORT with WithTCP).
+func WithDSNFile(filename string) Option {
+	return func(c *config) {
+		c.dsnFile = filename
+	}
+}
+
+// Load the audit log plugin, see AuditLog.
+//
+// This uses server_audit on MariaDB and the audit_log plugin on MySQL
+// Enterprise / Percona Server. The server still starts when the plugin isn't
+// installed.
+func WithAuditLog() Option {
+	return func(c *config) {
+		c.auditLog = true
+	}
+}
+
+// Run fn against the mysql system database, as root, before Start returns.
+//
+// This is meant for changes to the mysql.* tables (privileges, plugins) which
+// need to be in place before the server is handed out. It runs once the server
+// accepts connections, before anything touches the test database. Returning
+// an error stops the server and fails Start.
+func WithSystemInit(fn func(*sql.DB) error) Option {
+	return func(c *config) {
+		c.systemInit = fn
+	}
+}
+
+// Maximum size (in bytes) of internal in-memory temporary tables, e.g. those
+// used for GROUP BY. Larger ones are converted to on-disk tables.
+//
+// The effective limit is the smaller of this and WithMaxHeapTableSize, so
+// usually both need to be raised.
+func WithTmpTableSize(bytes int64) Option {
+	return func(c *config) {
+		c.set("tmp_table_size", fmt.Sprint(bytes))
+	}
+}
+
+// Maximum size (in bytes) of MEMORY tables, which also caps internal
+// in-memory temporary tables.
+func WithMaxHeapTableSize(bytes int64) Option {
+	return func(c *config) {
+		c.set("max_heap_table_size", fmt.Sprint(bytes))
+	}
+}
+
+// Load the given plugin shared libraries at startup.
+//
+// The files are copied into a private plugin directory, which replaces the
+// server's plugin_dir: plugins shipped with the server aren't available
+// unless passed here as well. Use PluginDir to find the directory.
+//
+// UDF libraries aren't plugins, the server only logs an error for them at
+// startup. Pass them here to get them into the plugin directory, then
+// register each function with CREATE FUNCTION ... SONAME, e.g. from
+// WithSystemInit.
+func WithPlugins(paths ...string) Option {
+	return func(c *config) {
+		c.pluginFiles = append(c.pluginFiles, paths...)
+	}
+}
+
+// Turn on the event scheduler, so CREATE EVENT statements actually fire.
+func WithEventScheduler() Option {
+	return func(c *config) {
+		c.set("event_scheduler", "ON")
+	}
+}
+
+// Create the temporary storage (data, logs and config) in dir instead of the
+// default temp directory, e.g. on a tmpfs mount for speed. The directory must
+// exist and be writable.
+//
+// A unique subdirectory is created in dir for each instance, Stop removes it.
+// Keep dir short, MySQL refuses socket paths over 107 characters.
+func WithBaseDir(dir string) Option {
+	return func(c *config) {
+		c.baseDir = dir
+	}
+}
+
+// Keep the general, error and slow query logs in a directory separate from the
+// data, e.g. when the data lives on a size-limited tmpfs.
+//
+// A unique subdirectory is created in dir for each instance, Stop removes it.
+func WithLogDir(dir string) Option {
+	return func(c *config) {
+		c.logDir = dir
+	}
+}
+
+// Host name and port the server reports to its replication source, as shown by
+// SHOW REPLICAS / SHOW SLAVE HOSTS. A port of 0 leaves report_port unset.
+func WithReportHost(host string, port int) Option {
+	return func(c *config) {
+		c.set("report_host", host)
+		if port != 0 {
+			c.set("report_port", fmt.Sprint(port))
+		}
+	}
+}
+
+// Have the server execute the SQL statements in filename while it boots,
+// before accepting connections (--init-file). Statements must be on a single
+// line each and shouldn't contain comments.
+//
+// Runs before WithInitSQL when both are given.
+func WithInitFile(filename string) Option {
+	return func(c *config) {
+		c.initFile = filename
+	}
+}
+
+// Same as WithInitFile, but with the statements given inline.
+func WithInitSQL(sql string) Option {
+	return func(c *config) {
+		c.initSQL = sql
+	}
+}
+
+// Seconds a transaction waits for an InnoDB row lock before giving up with
+// error 1205. Defaults to 5, so lock contention tests fail fast.
+func WithInnodbLockWaitTimeout(seconds int) Option {
+	return func(c *config) {
+		c.set("innodb_lock_wait_timeout", fmt.Sprint(seconds))
+	}
+}
+
+// Pin the server to the given CPUs, for more stable benchmark numbers.
+//
+// Linux only and best-effort: this uses taskset and is ignored on other
+// platforms or when taskset isn't installed.
+func WithCPUAffinity(cpus ...int) Option {
+	return func(c *config) {
+		c.cpuAffinity = cpus
+	}
+}
+
+// Enable the binary log with GTIDs, so the server can act as a replication
+// source or replica, see StartReplicationFrom.
+func WithGTID() Option {
+	return func(c *config) {
+		c.gtid = true
+	}
+}
+
+// How long binary logs are kept before the server removes them, to bound disk
+// usage in long running tests. Defaults to an hour, 0 disables expiry.
+//
+// MySQL before 8.0.1 and MariaDB before 10.6.1 only support whole days, so
+// the value is rounded up there.
+func WithBinlogExpire(d time.Duration) Option {
+	return func(c *config) {
+		c.binlogExpire = d
+	}
+}
+
+// Start the server with the given umask (e.g. 0027), making the permissions
+// of files it creates independent of the caller's umask. Unix only.
+//
+// Besides the process umask, this sets the UMASK and UMASK_DIR variables
+// mysqld uses as creation modes. Note that mysqld always grants the owner
+// read/write access to files it creates.
+func WithUmask(umask os.FileMode) Option {
+	return func(c *config) {
+		c.umask = &umask
+	}
+}
+
+// Give up when initializing the data directory takes longer than d, killing
+// the init process. By default there is no limit.
+func WithInitTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.initTimeout = d
+	}
+}
+
+// How long to wait for the server to accept connections once it's started,
+// 10 seconds by default. Doesn't include initializing the data directory, see
+// WithInitTimeout.
+func WithStartupTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.startupTimeout = d
+	}
+}
+
+// How long Stop waits for mysqladmin shutdown, 10 seconds by default.
+// mysqladmin only returns once the server has exited, so this bounds the whole
+// shutdown, including flushing dirty pages. When it takes longer (a slow
+// shutdown, or mysqladmin hanging on a password prompt), the server is killed
+// and Stop returns an error.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.shutdownTimeout = d
+	}
+}
+
+// Character set and collation of client connections (including DB), e.g. to
+// reproduce a legacy latin1 client. This doesn't change the server defaults.
+//
+// The collation implies the charset, when given it takes precedence. Either
+// can be left empty.
+func WithConnectionCharset(charset, collation string) Option {
+	return func(c *config) {
+		c.connCharset = charset
+		c.connCollation = collation
+	}
+}
+
+// Default character set and collation of the server (character_set_server,
+// collation_server), which new databases and tables inherit, e.g. "utf8mb4"
+// and "utf8mb4_unicode_ci". Client connections use them too, see
+// WithConnectionCharset. Either can be left empty.
+//
+// Start fails when the server doesn't know the collation (or it doesn't
+// belong to the charset).
+func WithCharset(charset, collation string) Option {
+	return func(c *config) {
+		if charset != "" {
+			c.set("character_set_server", charset)
+		}
+		if collation != "" {
+			c.set("collation_server", collation)
+		}
+		WithConnectionCharset(charset, collation)(c)
+	}
+}
+
+// Refuse to start when the installed server is older than v (e.g. "8.0").
+// The error wraps ErrUnsupportedVersion.
+//
+// MariaDB uses its own version numbers (10.x, 11.x), so a range only makes
+// sense for one flavor.
+func WithMinVersion(v string) Option {
+	return func(c *config) {
+		c.minVersion = v
+	}
+}
+
+// Refuse to start when the installed server is newer than v, see
+// WithMinVersion. Missing parts match anything: "8.0" allows all 8.0.x
+// releases.
+func WithMaxVersion(v string) Option {
+	return func(c *config) {
+		c.maxVersion = v
+	}
+}
+
+// Row format for InnoDB tables created without an explicit ROW_FORMAT:
+// DYNAMIC (the server default), COMPACT or REDUNDANT.
+//
+// COMPRESSED can't be a default, use ROW_FORMAT=COMPRESSED on the table along
+// with WithCompressedTables.
+func WithDefaultRowFormat(format string) Option {
+	return func(c *config) {
+		c.set("innodb_default_row_format", format)
+	}
+}
+
+// Make sure tables with ROW_FORMAT=COMPRESSED can be created and written:
+// this needs file-per-table tablespaces, and MariaDB 10.6 made such tables
+// read-only by default.
+func WithCompressedTables() Option {
+	return func(c *config) {
+		c.set("innodb_file_per_table", "ON")
+		c.set("loose-innodb_read_only_compressed", "OFF")
+	}
+}
+
+// Call fn when the server doesn't become ready in time, before Start returns
+// the error. It receives the error log and whether the server process was
+// still running.
+func WithOnReadyTimeout(fn func(logs string)) Option {
+	return func(c *config) {
+		c.onReadyTimeout = fn
+	}
+}
+
+// Seconds a statement waits for a metadata lock (e.g. an ALTER TABLE blocked
+// by an open transaction) before failing. Defaults to 5, instead of the
+// server's one year.
+func WithLockWaitTimeout(seconds int) Option {
+	return func(c *config) {
+		c.set("lock_wait_timeout", fmt.Sprint(seconds))
+	}
+}
+
+// Abort statements running longer than ms milliseconds, with error 3024 on
+// MySQL (which only applies this to SELECT) and 1969 on MariaDB (where this
+// sets max_statement_time). Unlimited by default.
+func WithMaxExecutionTime(ms int) Option {
+	return func(c *config) {
+		c.maxExecutionTime = ms
+	}
+}
+
+// Delay binary log syncs by up to delay to group more commits together, like
+// a tuned production server. The wait ends early once maxCount transactions
+// are queued, 0 means no such limit.
+//
+// Sets binlog_group_commit_sync_delay / binlog_group_commit_sync_no_delay_count
+// on MySQL and binlog_commit_wait_usec / binlog_commit_wait_count on MariaDB.
+// Only has an effect when binary logging is enabled.
+func WithGroupCommitDelay(delay time.Duration, maxCount int) Option {
+	return func(c *config) {
+		c.groupCommitDelay = delay
+		c.groupCommitCount = maxCount
+	}
+}
+
+// Flush the InnoDB redo log every n seconds (innodb_flush_log_at_timeout),
+// only relevant when innodb_flush_log_at_trx_commit is 0 or 2.
+func WithFlushLogAtTimeout(seconds int) Option {
+	return func(c *config) {
+		c.set("innodb_flush_log_at_timeout", fmt.Sprint(seconds))
+	}
+}
+
+// Don't sync InnoDB and binary log writes to disk on every commit, which makes
+// insert-heavy tests a lot faster (like fsync = off on PostgreSQL).
+//
+// A crash of the server or the machine loses recent transactions and can
+// corrupt the data, only use this for throwaway test data.
+func WithFastInsecure() Option {
+	return func(c *config) {
+		c.set("innodb_flush_log_at_trx_commit", "0")
+		c.set("sync_binlog", "0")
+		c.set("innodb_doublewrite", "0")
+	}
+}
+
+// Name of the test database, instead of "test". It's created when missing.
+// Names must match ^[A-Za-z_][A-Za-z0-9_]*$, StartWithOptions fails otherwise.
+func WithDatabaseName(name string) Option {
+	return func(c *config) {
+		c.dbName = name
+	}
+}
+
+// Put the MySQL data directory at dir instead of in temporary storage. When it
+// already holds an initialized data directory (e.g. one kept with
+// WithKeepData), initialization is skipped and the existing data is used.
+//
+// Stop never removes this directory.
+func WithDataDir(dir string) Option {
+	return func(c *config) {
+		c.dataDir = dir
+	}
+}
+
+// Keep the temporary storage (data, logs and config) around after Stop, e.g.
+// to inspect it after a failure or to start a new instance on the same data
+// with WithDataDir. See DataDir and LogDir for the locations.
+func WithKeepData(keep bool) Option {
+	return func(c *config) {
+		c.keepData = keep
+	}
+}
+
+// Preload a shared library into the server through LD_PRELOAD (Linux), e.g. to
+// run it with jemalloc or tcmalloc:
+//
+//	mysqltest.WithPreload("/usr/lib/x86_64-linux-gnu/libjemalloc.so.2")
+//
+// Multiple libraries can be given separated by spaces.
+func WithPreload(lib string) Option {
+	return func(c *config) {
+		c.preload = lib
+	}
+}
+
+// Set what ends up in the error log (log_error_verbosity): 1 for errors only,
+// 2 to include warnings and 3 to include notes as well. On MariaDB this maps
+// onto log_warnings.
+func WithLogErrorVerbosity(level int) Option {
+	return func(c *config) {
+		c.logVerbosity = level
+	}
+}
+
+// Listen on a free TCP port on 127.0.0.1 next to the unix socket, for clients
+// that can't use sockets. DB, DSN and ConnectionParams connect over TCP, see
+// Port for the chosen port.
+func WithTCP() Option {
+	return func(c *config) {
+		c.tcp = true
+	}
+}
+
+// Language of server error messages (lc_messages), e.g. "de_DE". Defaults to
+// "en_US", so tests matching on messages don't depend on the locale.
+func WithMessages(locale string) Option {
+	return func(c *config) {
+		c.set("lc_messages", locale)
+	}
+}
+
+// Don't use native asynchronous I/O (libaio, or io_uring on newer MariaDB),
+// for environments where those syscalls are blocked, e.g. by a seccomp
+// profile.
+func WithDisableIOUring() Option {
+	return func(c *config) {
+		c.set("innodb_use_native_aio", "0")
+	}
+}
+
+// Avoid server features that commonly fail in restricted containers and
+// sandboxes:
+//
+//   - native asynchronous I/O (see WithDisableIOUring)
+//   - NUMA memory policies (mbind)
+//   - O_DIRECT, which isn't supported by all (overlay) filesystems (not
+//     changed on Windows, which has no fsync flush method)
+func WithCompatMode() Option {
+	return func(c *config) {
+		WithDisableIOUring()(c)
+		// Not every build has NUMA support, loose- ignores unknown options
+		c.set("loose-innodb_numa_interleave", "0")
+		if runtime.GOOS != "windows" {
+			c.set("innodb_flush_method", "fsync")
+		}
+	}
+}
+
+// Execute the statements in a schema file (see ExecFile) against the test
+// database once the server is up. Start fails when one of them does.
+//
+// Can be given multiple times, together with WithSchema, and runs in order.
+func WithSchemaFile(filename string) Option {
+	return func(c *config) {
+		c.schemas = append(c.schemas, schema{file: filename})
+	}
+}
+
+// Like WithSchemaFile, with the statements given inline.
+func WithSchema(sql string) Option {
+	return func(c *config) {
+		c.schemas = append(c.schemas, schema{sql: sql})
+	}
+}
+
+// Give root a password instead of none. DB, DSN and ConnectionParams use it.
+func WithRootPassword(password string) Option {
+	return func(c *config) {
+		c.rootPassword = password
+	}
+}
+
+// Create a user that can log in from localhost (including the socket) with
+// the given privileges on the test database, e.g. "SELECT", "INSERT". All
+// privileges are granted when none are given.
+//
+// See UserDSN to connect as the user.
+func WithUser(name, password string, grants ...string) Option {
+	return func(c *config) {
+		c.users = append(c.users, userSpec{name, password, grants})
+	}
+}
+
+// Create additional databases next to the test database, see DBFor to connect
+// to them. Users created with WithUser only have access to the test
+// database.
+func WithDatabases(names ...string) Option {
+	return func(c *config) {
+		c.databases = append(c.databases, names...)
+	}
+}
+
+// Add settings to the [mysqld] section of the server configuration, e.g.
+// "max_connections": "500". Values are quoted as needed, an empty value writes
+// a flag like "skip-name-resolve".
+//
+// These take precedence over everything else: the defaults written by
+// mysqltest (e.g. "general_log": "0" turns off the query log) and the
+// settings of other options. Settings mysqltest depends on can't be changed:
+// the data directory, socket and log file locations, networking (port,
+// bind_address, skip_networking), server_id and plugin_dir. Start fails when
+// one of them is given.
+func WithConfig(settings map[string]string) Option {
+	return func(c *config) {
+		for k, v := range settings {
+			c.custom[normalizeOption(k)] = v
+		}
+	}
+}
+
+// Use the MySQL / MariaDB executables in dir (e.g. a Homebrew keg or an
+// unpacked release) instead of finding them in $PATH. Allows testing against
+// several versions from one test binary.
+func WithBinPath(dir string) Option {
+	return func(c *config) {
+		c.binPath = dir
+	}
+}
+
+// Report the progress of Start (finding executables, initialization, server
+// start, first connection) through logger, e.g. t.Logf or log.Printf. Each
+// message includes the time elapsed since Start was called, to find out which
+// step is slow.
+func WithLogger(logger func(format string, args ...interface{})) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// Fill the test database with fn before Start returns, after the schemas
+// given with WithSchema / WithSchemaFile are loaded. Start stops the server
+// and fails when fn does.
+func WithSeed(fn func(db *sql.DB) error) Option {
+	return func(c *config) {
+		c.seeds = append(c.seeds, fn)
+	}
+}
+
+// Initialize the data directory once and keep a copy in cacheDir, later
+// starts (also from other processes) copy it instead of initializing again,
+// which is much quicker.
+//
+// The cache has an entry per server version and configuration, it's never
+// cleaned up.
+func WithCachedInit(cacheDir string) Option {
+	return func(c *config) {
+		c.initCache = cacheDir
+	}
+}
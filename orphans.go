@@ -0,0 +1,84 @@
+package mysqltest
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Written to each instance directory, holding the PID of the process that
+// started it.
+const ownerFile = "owner.pid"
+
+// Written instead of the owner file when the data is kept (WithKeepData), so
+// the directory is never considered an orphan.
+const keepFile = "keep"
+
+// Remove the temporary directories of instances whose process is gone, e.g.
+// because the test binary was killed before it could call Stop. Returns how
+// many were removed.
+//
+// Only directories with an owner file are considered, so those of other tools
+// (and WithLogDir directories) are never touched. Directories of instances
+// that are still running, in any process, and of instances started with
+// WithKeepData are left alone.
+func CleanupOrphans() (int, error) {
+	return cleanupOrphans(os.TempDir())
+}
+
+func cleanupOrphans(root string) (int, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, fi := range entries {
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), "mysqltest") {
+			continue
+		}
+
+		dir := filepath.Join(root, fi.Name())
+		if !isOrphan(dir) {
+			continue
+		}
+
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func isOrphan(dir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		return false
+	}
+
+	// A server still listening means it's in use, whoever owns it
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, "sock", "mysql.sock"), time.Second)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+func writeOwnerFile(dir string, keep bool) error {
+	if keep {
+		return ioutil.WriteFile(filepath.Join(dir, keepFile), nil, 0644)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
@@ -0,0 +1,110 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var testDatabaseCount uint64
+
+// Start a new MySQL database for the test, stopped again when the test
+// finishes. Fails the test when the server doesn't start.
+//
+//	func TestSomething(t *testing.T) {
+//		mysql := mysqltest.StartT(t)
+//		...
+//	}
+func StartT(tb testing.TB, opts ...Option) *MySQL {
+	tb.Helper()
+
+	mysql, err := StartWithOptions(opts...)
+	if err != nil {
+		tb.Fatalf("Failed to start MySQL: %s", err)
+	}
+
+	tb.Cleanup(func() {
+		err := mysql.Stop()
+		if err != nil {
+			tb.Errorf("Failed to stop MySQL: %s", err)
+		}
+	})
+	return mysql
+}
+
+// Create a fresh database for the test, dropped again when the test finishes.
+//
+// This gives each test its own schema on a shared server, so parallel tests
+// don't interfere:
+//
+//	func TestSomething(t *testing.T) {
+//		t.Parallel()
+//		db := server.ForTest(t)
+//		...
+//	}
+func (p *MySQL) ForTest(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	name := testDatabaseName(tb.Name(), atomic.AddUint64(&testDatabaseCount, 1))
+	_, err := p.DB.Exec("CREATE DATABASE " + quoteIdent(name))
+	if err != nil {
+		tb.Fatalf("Failed to create database: %s", err)
+	}
+
+	db, err := sql.Open("mysql", p.dsn("root", name))
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		p.DB.Exec("DROP DATABASE " + quoteIdent(name))
+		tb.Fatalf("Failed to connect to database: %s", err)
+	}
+
+	tb.Cleanup(func() {
+		db.Close()
+		_, err := p.DB.Exec("DROP DATABASE IF EXISTS " + quoteIdent(name))
+		if err != nil {
+			tb.Errorf("Failed to drop database: %s", err)
+		}
+	})
+	return db
+}
+
+// Unique database name derived from the test name, within the 64 character
+// limit.
+func testDatabaseName(testName string, n uint64) string {
+	suffix := fmt.Sprintf("_%d", n)
+
+	var b strings.Builder
+	b.WriteString("test_")
+	for _, r := range strings.ToLower(testName) {
+		if b.Len()+len(suffix) >= 64 {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String() + suffix
+}
+
+// Run fn in a transaction on DB that is always rolled back afterwards (also
+// when fn panics or fails the test), so the test leaves no data behind.
+//
+// Schema changes (CREATE TABLE, ALTER TABLE, ...) commit implicitly and
+// aren't undone, use ForTest for those.
+func (p *MySQL) WithinTransaction(tb testing.TB, fn func(tx *sql.Tx)) {
+	tb.Helper()
+
+	tx, err := p.DB.Begin()
+	if err != nil {
+		tb.Fatalf("Failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	fn(tx)
+}
@@ -0,0 +1,146 @@
+package mysqltest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A set of warm MySQL instances, which are handed out with Get and returned
+// with Put. This amortizes the startup cost across a test suite.
+//
+// A Pool is safe for concurrent use.
+type Pool struct {
+	opts []Option
+	size int
+
+	mu     sync.Mutex
+	idle   []*MySQL
+	all    map[*MySQL]bool
+	closed bool
+}
+
+// Start a pool of size instances, configured with the given options.
+func NewPool(size int, opts ...Option) (*Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("Invalid pool size: %d", size)
+	}
+
+	pool := &Pool{
+		opts: opts,
+		size: size,
+		all:  make(map[*MySQL]bool),
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, size)
+	instances := make([]*MySQL, size)
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instances[i], errs[i] = StartWithOptions(opts...)
+		}(i)
+	}
+	wg.Wait()
+
+	var err error
+	for i, mysql := range instances {
+		if mysql != nil {
+			pool.idle = append(pool.idle, mysql)
+			pool.all[mysql] = true
+		}
+		if errs[i] != nil && err == nil {
+			err = errs[i]
+		}
+	}
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// Get an instance with an empty test database. Starts a new instance when all
+// of them are in use.
+func (p *Pool) Get() (*MySQL, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("Pool is closed")
+	}
+
+	var mysql *MySQL
+	if len(p.idle) > 0 {
+		mysql = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+	}
+	p.mu.Unlock()
+
+	if mysql == nil {
+		started, err := StartWithOptions(p.opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.track(started)
+		return started, nil
+	}
+
+	err := mysql.Reset()
+	if err != nil {
+		p.discard(mysql)
+		return nil, err
+	}
+	return mysql, nil
+}
+
+// Return an instance obtained with Get. Instances beyond the pool size are
+// stopped.
+func (p *Pool) Put(mysql *MySQL) {
+	p.mu.Lock()
+	if !p.closed && p.all[mysql] && len(p.idle) < p.size {
+		p.idle = append(p.idle, mysql)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	p.discard(mysql)
+}
+
+// Stop all instances, including those that are still in use.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	all := p.all
+	p.all = make(map[*MySQL]bool)
+	p.idle = nil
+	p.mu.Unlock()
+
+	var err error
+	for mysql := range all {
+		e := mysql.Stop()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (p *Pool) track(mysql *MySQL) {
+	p.mu.Lock()
+	p.all[mysql] = true
+	p.mu.Unlock()
+}
+
+func (p *Pool) discard(mysql *MySQL) {
+	p.mu.Lock()
+	owned := p.all[mysql]
+	delete(p.all, mysql)
+	p.mu.Unlock()
+
+	if owned {
+		mysql.Stop()
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+// +build !windows
+
+package mysqltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Runs the server, restarting mysqld when it crashes.
+const serverBinary = "mysqld_safe"
+
+// Put the command in its own process group, so killProcessGroup can take
+// down everything it spawned.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// Run the command as the mysql user, with a login environment like su would
+// give it. Unlike su, this doesn't start a new session, so killProcessGroup
+// still reaches the command.
+//
+// Start already checked that the user exists, the command runs unchanged (and
+// mysqld refuses to run as root) when it doesn't.
+func dropPrivileges(cmd *exec.Cmd) {
+	u, err := user.Lookup("mysql")
+	if err != nil {
+		return
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	cmd.Env = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"PATH=" + os.Getenv("PATH"),
+	}
+
+	// The working directory of the caller might not be accessible
+	cmd.Dir = "/"
+	if fi, err := os.Stat(u.HomeDir); err == nil && fi.IsDir() {
+		cmd.Dir = u.HomeDir
+	}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func killPID(pid int) error {
+	err := syscall.Kill(pid, syscall.SIGKILL)
+	if err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// Parent of a process, 0 if unknown (only implemented on Linux).
+func parentPID(pid int) int {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+
+	// Formatted as "pid (comm) state ppid ...", comm can contain spaces
+	s := string(stat)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0
+	}
+	return ppid
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	// Exited children that weren't waited for yet still accept signals
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err == nil && strings.Contains(string(stat), ") Z ") {
+		return false
+	}
+	return true
+}
+
+// Whether any process of the group led by pgid is left, see setProcessGroup.
+func processGroupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func inProcessGroup(pid, pgid int) bool {
+	g, err := syscall.Getpgid(pid)
+	return err == nil && g == pgid
+}
+
+// Give dst the owner of the file described by fi.
+func chownLike(dst string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(dst, int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,65 @@
+package mysqltest
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// There's no mysqld_safe on Windows, the server runs directly.
+const serverBinary = "mysqld"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Never running as root on Windows.
+func dropPrivileges(cmd *exec.Cmd) {}
+
+// Kills the whole process tree, Windows has no process groups to signal.
+func killProcessGroup(cmd *exec.Cmd) {
+	exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+func killPID(pid int) error {
+	h, err := syscall.OpenProcess(syscall.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		// Already gone
+		return nil
+	}
+	defer syscall.CloseHandle(h)
+	return syscall.TerminateProcess(h, 1)
+}
+
+// Not needed: there's no wrapper process that restarts the server.
+func parentPID(pid int) int {
+	return 0
+}
+
+const stillActive = 259
+
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	err = syscall.GetExitCodeProcess(h, &code)
+	return err == nil && code == stillActive
+}
+
+// killProcessGroup waits for taskkill, which ends the whole tree.
+func processGroupAlive(pgid int) bool {
+	return processAlive(pgid)
+}
+
+// No process groups, the server is cmd itself anyway.
+func inProcessGroup(pid, pgid int) bool {
+	return pid == pgid
+}
+
+// Only needed when running as root, which is never the case on Windows.
+func chownLike(dst string, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package mysqltest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Sockets (and later ports) claimed by live instances in this process. Two
+// instances sharing one would interfere in confusing ways, so this is checked
+// for every Start.
+var registry = struct {
+	sync.Mutex
+	claimed map[string]bool
+}{
+	claimed: make(map[string]bool),
+}
+
+func claim(resource string) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.claimed[resource] {
+		return fmt.Errorf("Conflict: %s is already in use by another instance", resource)
+	}
+	registry.claimed[resource] = true
+	return nil
+}
+
+func release(resource string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	delete(registry.claimed, resource)
+}
@@ -0,0 +1,183 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var serverIDs uint32
+
+// Each instance gets its own server_id, replication needs them to differ.
+func nextServerID() uint32 {
+	return atomic.AddUint32(&serverIDs, 1)
+}
+
+// The set of GTIDs executed by the server.
+//
+// On MySQL this is @@global.gtid_executed, on MariaDB (which uses a different
+// GTID format) @@global.gtid_binlog_pos.
+func (p *MySQL) GTIDExecuted() (string, error) {
+	variable := "@@global.gtid_executed"
+	if p.isMariaDB {
+		variable = "@@global.gtid_binlog_pos"
+	}
+
+	var gtids string
+	err := p.DB.QueryRow("SELECT " + variable).Scan(&gtids)
+	if err != nil {
+		return "", err
+	}
+	return gtids, nil
+}
+
+// Current binary log file and position, from SHOW MASTER STATUS (SHOW BINARY
+// LOG STATUS since MySQL 8.2, which removed the old name in 8.4).
+func (p *MySQL) BinlogPosition() (file string, pos uint64, err error) {
+	query := "SHOW MASTER STATUS"
+	if !p.isMariaDB && p.version.AtLeast(8, 2, 0) {
+		query = "SHOW BINARY LOG STATUS"
+	}
+
+	rows, err := p.DB.Query(query)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err == nil {
+			err = fmt.Errorf("Binary logging is not enabled")
+		}
+		return "", 0, err
+	}
+
+	// The number of columns differs between MySQL and MariaDB, File and
+	// Position always come first.
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	if err != nil {
+		return "", 0, err
+	}
+
+	pos, err = strconv.ParseUint(string(values[1]), 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(values[0]), pos, nil
+}
+
+// Delay applying replicated events by d (rounded up to whole seconds), e.g. to
+// test how code copes with replication lag. A zero delay turns it off again.
+//
+// Only works on a server that is set up as a replica. The SQL thread is
+// restarted to apply the change.
+func (p *MySQL) SetReplicationDelay(d time.Duration) error {
+	seconds := int64((d + time.Second - 1) / time.Second)
+
+	replica, change, delay := "SLAVE", "CHANGE MASTER TO", "MASTER_DELAY"
+	if p.replicaSyntax() {
+		replica, change, delay = "REPLICA", "CHANGE REPLICATION SOURCE TO", "SOURCE_DELAY"
+	}
+
+	_, err := p.DB.Exec("STOP " + replica + " SQL_THREAD")
+	if err != nil {
+		return fmt.Errorf("Failed to stop replication: %w", err)
+	}
+
+	_, err = p.DB.Exec(fmt.Sprintf("%s %s = %d", change, delay, seconds))
+	if err != nil {
+		return fmt.Errorf("Failed to set replication delay: %w", err)
+	}
+
+	_, err = p.DB.Exec("START " + replica + " SQL_THREAD")
+	if err != nil {
+		return fmt.Errorf("Failed to start replication: %w", err)
+	}
+	return nil
+}
+
+// Whether to use the REPLICA / SOURCE statements, MySQL 8.0.23 added them and
+// 8.4 removed the SLAVE / MASTER ones. MariaDB keeps the old syntax.
+func (p *MySQL) replicaSyntax() bool {
+	return !p.isMariaDB && p.version.AtLeast(8, 0, 23)
+}
+
+// Replicate from src, starting after the given GTID set, e.g. to test a
+// consumer that resumes from a stored position. Transactions in gtidSet are
+// treated as already applied here and aren't replicated, pass
+// src.GTIDExecuted() from right after Start to skip the source's own setup.
+//
+// Both servers need WithGTID and src also needs WithTCP, replicas connect over
+// TCP. Any existing replication on this server is replaced.
+func (p *MySQL) StartReplicationFrom(src *MySQL, gtidSet string) error {
+	if src.port == 0 {
+		return fmt.Errorf("Replication source must be started with WithTCP")
+	}
+
+	if p.isMariaDB {
+		return p.startMariaDBReplication(src, gtidSet)
+	}
+
+	replica, change, source := "SLAVE", "CHANGE MASTER TO", "MASTER"
+	if p.replicaSyntax() {
+		replica, change, source = "REPLICA", "CHANGE REPLICATION SOURCE TO", "SOURCE"
+	}
+
+	// gtid_purged can only be set while gtid_executed is empty, every server
+	// has some of its own setup in there.
+	reset := "RESET MASTER"
+	if p.version.AtLeast(8, 2, 0) {
+		reset = "RESET BINARY LOGS AND GTIDS"
+	}
+
+	stmts := []string{
+		"STOP " + replica,
+		reset,
+		"SET GLOBAL gtid_purged = " + quoteString(gtidSet),
+	}
+	change = fmt.Sprintf("%s %s_HOST = '127.0.0.1', %s_PORT = %d, %s_USER = 'root', %s_PASSWORD = %s, %s_AUTO_POSITION = 1",
+		change, source, source, src.port, source, source, quoteString(src.rootPassword), source)
+	if p.version.AtLeast(8, 0, 4) {
+		// caching_sha2_password needs the key without TLS
+		change += fmt.Sprintf(", GET_%s_PUBLIC_KEY = 1", source)
+	}
+	stmts = append(stmts, change, "START "+replica)
+	return p.execReplication(stmts)
+}
+
+// MariaDB has its own GTID format, the position to start from goes in
+// gtid_slave_pos.
+func (p *MySQL) startMariaDBReplication(src *MySQL, gtidSet string) error {
+	return p.execReplication([]string{
+		"STOP SLAVE",
+		"SET GLOBAL gtid_slave_pos = " + quoteString(gtidSet),
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST = '127.0.0.1', MASTER_PORT = %d, MASTER_USER = 'root', MASTER_PASSWORD = %s, MASTER_USE_GTID = slave_pos",
+			src.port, quoteString(src.rootPassword)),
+		"START SLAVE",
+	})
+}
+
+// The statements aren't part of errors, CHANGE MASTER holds the source's root
+// password.
+func (p *MySQL) execReplication(stmts []string) error {
+	for _, stmt := range stmts {
+		_, err := p.DB.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("Failed to start replication: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Column metadata, as found in information_schema.columns.
+type Column struct {
+	Name string
+
+	// Data type without size or attributes, e.g. "varchar"
+	DataType string
+
+	// Full type, e.g. "varchar(255)" or "int(10) unsigned"
+	ColumnType string
+
+	Nullable bool
+	Default  sql.NullString
+
+	// PRI, UNI, MUL or empty
+	Key string
+
+	// Additional info, e.g. auto_increment
+	Extra string
+}
+
+// Columns of a table in the test database, in table order.
+func (p *MySQL) Columns(table string) ([]Column, error) {
+	rows, err := p.DB.Query(`SELECT column_name, data_type, column_type, is_nullable, column_default, column_key, extra
+		FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY ordinal_position`, p.dbName, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var c Column
+		var nullable string
+		err = rows.Scan(&c.Name, &c.DataType, &c.ColumnType, &nullable, &c.Default, &c.Key, &c.Extra)
+		if err != nil {
+			return nil, err
+		}
+		c.Nullable = nullable == "YES"
+		columns = append(columns, c)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("Table %s not found", table)
+	}
+	return columns, nil
+}
+
+// The CREATE TABLE statement for a table in the test database, as returned by
+// SHOW CREATE TABLE.
+func (p *MySQL) CreateTableStatement(table string) (string, error) {
+	var name, stmt string
+	err := p.DB.QueryRow("SHOW CREATE TABLE "+quoteIdent(table)).Scan(&name, &stmt)
+	if err != nil {
+		return "", err
+	}
+	return stmt, nil
+}
+
+// The CREATE TABLE statements of all tables in the test database, sorted by
+// table name and separated by blank lines. Suitable for golden files.
+func (p *MySQL) DumpSchema() (string, error) {
+	tables, err := p.tableNames()
+	if err != nil {
+		return "", err
+	}
+
+	stmts := make([]string, 0, len(tables))
+	for _, table := range tables {
+		stmt, err := p.CreateTableStatement(table)
+		if err != nil {
+			return "", err
+		}
+		stmts = append(stmts, stmt+";\n")
+	}
+	return strings.Join(stmts, "\n"), nil
+}
+
+// Names of all base tables in the test database, sorted.
+func (p *MySQL) tableNames() ([]string, error) {
+	rows, err := p.DB.Query(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = ? AND table_type = 'BASE TABLE'
+		ORDER BY table_name`, p.dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		err = rows.Scan(&table)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// A partition (or subpartition) of a table, as found in
+// information_schema.partitions.
+type PartitionInfo struct {
+	Name             string
+	SubpartitionName string
+
+	// E.g. RANGE, LIST, HASH, KEY (RANGE COLUMNS, ...)
+	Method     string
+	Expression string
+
+	// Upper bound for RANGE, values for LIST
+	Description string
+
+	// Approximate, InnoDB only estimates this
+	Rows int64
+}
+
+// Partitions of a table in the test database, in partition order. Empty when
+// the table isn't partitioned.
+func (p *MySQL) Partitions(table string) ([]PartitionInfo, error) {
+	rows, err := p.DB.Query(`SELECT partition_name, IFNULL(subpartition_name, ''),
+			IFNULL(partition_method, ''), IFNULL(partition_expression, ''),
+			IFNULL(partition_description, ''), IFNULL(table_rows, 0)
+		FROM information_schema.partitions
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY partition_ordinal_position, subpartition_ordinal_position`, p.dbName, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	var partitions []PartitionInfo
+	for rows.Next() {
+		found = true
+
+		var name sql.NullString
+		var part PartitionInfo
+		err = rows.Scan(&name, &part.SubpartitionName, &part.Method, &part.Expression, &part.Description, &part.Rows)
+		if err != nil {
+			return nil, err
+		}
+
+		// Unpartitioned tables have a single row without a name
+		if !name.Valid {
+			continue
+		}
+		part.Name = name.String
+		partitions = append(partitions, part)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Table %s not found", table)
+	}
+	return partitions, nil
+}
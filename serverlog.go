@@ -0,0 +1,77 @@
+package mysqltest
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// How much server output is kept, older output is dropped.
+const serverLogSize = 1 << 20
+
+// Output of the server process (stdout and stderr of mysqld_safe), which
+// mostly reports starts and crashes, the error log has the details (see
+// ErrorLog). Bounded to the last 1MB.
+func (p *MySQL) ServerLog() (string, error) {
+	if p.output == nil {
+		return "", errors.New("Server was not started")
+	}
+	return p.output.String(), nil
+}
+
+// Keeps the last max bytes written to it.
+type ringBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func newRingBuffer(max int) *ringBuffer {
+	return &ringBuffer{max: max}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > r.max {
+		r.buf = append(r.buf[:0], r.buf[len(r.buf)-r.max:]...)
+	}
+	return len(p), nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return string(r.buf)
+}
+
+// Copy the server output into the buffer until the pipes are closed, done is
+// closed afterwards.
+func captureOutput(dst io.Writer, done chan struct{}, pipes ...io.Reader) {
+	var wg sync.WaitGroup
+	for _, pipe := range pipes {
+		wg.Add(1)
+		go func(pipe io.Reader) {
+			defer wg.Done()
+			io.Copy(dst, pipe)
+		}(pipe)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// Wait (a bit) for the remaining output of an exiting server. mysqld can keep
+// the pipes open after mysqld_safe is gone, so this doesn't wait forever.
+func (p *MySQL) drainOutput(timeout time.Duration) {
+	select {
+	case <-p.outputDone:
+	case <-time.After(timeout):
+	}
+}
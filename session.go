@@ -0,0 +1,53 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Run fn on a dedicated connection with the session sql_mode set to mode,
+// restoring the previous mode afterwards.
+func (p *MySQL) WithSQLMode(mode string, fn func(*sql.Conn) error) error {
+	return p.withSessionVariable("sql_mode", mode, fn)
+}
+
+// Run fn on a dedicated connection on which statements are aborted after ms
+// milliseconds, see the WithMaxExecutionTime option for the details.
+func (p *MySQL) WithMaxExecutionTime(ms int, fn func(*sql.Conn) error) error {
+	if p.isMariaDB {
+		return p.withSessionVariable("max_statement_time", float64(ms)/1000, fn)
+	}
+	return p.withSessionVariable("max_execution_time", ms, fn)
+}
+
+func (p *MySQL) withSessionVariable(name string, value interface{}, fn func(*sql.Conn) error) (fnErr error) {
+	ctx := context.Background()
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Keeping it in a user variable preserves its type
+	_, err = conn.ExecContext(ctx, "SET @mysqltest_previous = @@SESSION."+name)
+	if err != nil {
+		return err
+	}
+
+	// The connection goes back to the pool, don't leak the setting (even when
+	// fn panics)
+	defer func() {
+		_, err := conn.ExecContext(ctx, "SET SESSION "+name+" = @mysqltest_previous")
+		if err != nil && fnErr == nil {
+			fnErr = fmt.Errorf("Failed to restore %s: %w", name, err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, "SET SESSION "+name+" = ?", value)
+	if err != nil {
+		return fmt.Errorf("Failed to set %s: %w", name, err)
+	}
+
+	return fn(conn)
+}
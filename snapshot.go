@@ -0,0 +1,143 @@
+package mysqltest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Save the current state of all data, to return to it later with Restore.
+// Useful to seed a large data set once and restore it between tests, which
+// is much quicker than inserting it again.
+//
+// The server is stopped while the data directory is copied. The snapshot is
+// kept next to it and removed by Stop.
+func (p *MySQL) Snapshot() (string, error) {
+	_, err := p.DB.Exec("FLUSH TABLES")
+	if err != nil {
+		return "", fmt.Errorf("Failed to flush tables: %w", err)
+	}
+
+	snapshot, err := ioutil.TempDir(p.dir, "snapshot")
+	if err != nil {
+		return "", err
+	}
+	p.snapshots = append(p.snapshots, snapshot)
+
+	err = p.stopForCopy()
+	if err == nil {
+		err = copyDir(p.dataDir, snapshot, p.isRoot)
+		if err != nil {
+			err = fmt.Errorf("Failed to copy data directory: %w", err)
+		}
+	}
+
+	// Restart regardless, the shutdown or copy error matters most
+	rerr := p.restart()
+	if err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// Return to the state saved with Snapshot. The snapshot stays intact, so it
+// can be restored again.
+//
+// Connections are dropped by the server restart, DB reconnects.
+func (p *MySQL) Restore(snapshot string) error {
+	err := p.stopForCopy()
+	if err != nil {
+		// Still on the old data, which is intact
+		rerr := p.restart()
+		if rerr != nil {
+			err = fmt.Errorf("%w (restart: %s)", err, rerr)
+		}
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(p.dataDir)
+	if err == nil {
+		for _, fi := range entries {
+			err = os.RemoveAll(filepath.Join(p.dataDir, fi.Name()))
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = copyDir(snapshot, p.dataDir, p.isRoot)
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to restore data directory: %w", err)
+	}
+
+	rerr := p.restart()
+	if err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Shut the server down to copy its data directory. When that fails it's
+// killed, so it can be restarted either way.
+func (p *MySQL) stopForCopy() error {
+	err := p.shutdown(context.Background())
+	if err != nil {
+		p.Kill()
+	}
+	return err
+}
+
+// Start the server again after shutdown, on the same configuration.
+func (p *MySQL) restart() error {
+	offset := fileSize(p.errorLogFile)
+	err := p.launch()
+	if err != nil {
+		return err
+	}
+
+	rootDB, err := p.waitReady(context.Background(), offset)
+	if err != nil {
+		p.Kill()
+		return fmt.Errorf("Failed to restart DB: %w\nERROR LOG: %s", err, p.errorLogTail(20))
+	}
+	rootDB.Close()
+
+	// Pooled connections were closed by the server
+	return p.DB.Ping()
+}
+
+// Recursively copy src into dst (which has to exist), keeping the owner when
+// running as root.
+func copyDir(src, dst string, keepOwner bool) error {
+	return filepath.Walk(src, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if fi.IsDir() {
+			err = os.MkdirAll(target, fi.Mode().Perm())
+		} else {
+			err = copyFile(name, target, fi.Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+
+		if keepOwner {
+			return chownLike(target, fi)
+		}
+		return nil
+	})
+}
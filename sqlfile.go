@@ -0,0 +1,185 @@
+package mysqltest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Execute the statements in an SQL file against the test database, one at a
+// time.
+//
+// Files compressed with gzip (.sql.gz) are decompressed transparently. The
+// DELIMITER client command is supported (e.g. for triggers written by
+// mysqldump), for other client commands use LoadDump.
+func (p *MySQL) ExecFile(filename string) error {
+	r, err := openSQLFile(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %w", filename, err)
+	}
+
+	return p.execStatements(string(data))
+}
+
+func (p *MySQL) execStatements(script string) error {
+	for i, stmt := range splitStatements(script) {
+		_, err := p.DB.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("Statement %d failed: %w\n%s", i+1, err, stmt)
+		}
+	}
+	return nil
+}
+
+// Open an SQL file, decompressing it when it's gzipped (detected by the
+// extension or the gzip magic header).
+func openSQLFile(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(2)
+	isGzip := strings.HasSuffix(filename, ".gz") || (len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b)
+	if !isGzip {
+		return readCloser{br, f}, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to decompress %s: %w", filename, err)
+	}
+	return readCloser{gz, multiCloser{gz, f}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m {
+		e := c.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Split a script into statements on semicolons (or the delimiter set with
+// DELIMITER), ignoring those in quoted strings, identifiers and comments.
+// Empty statements are dropped.
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	delimiter := ";"
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// Copy the quoted section verbatim
+			end := i + 1
+			for end < len(script) {
+				if script[end] == '\\' && c != '`' {
+					end += 2
+					continue
+				}
+				if script[end] == c {
+					// Doubled quote is an escaped quote
+					if end+1 < len(script) && script[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			current.WriteString(script[i : end+1])
+			i = end
+		case (c == 'D' || c == 'd') && isDelimiterCommand(script[i:]) && strings.TrimSpace(current.String()) == "":
+			// Client command, only valid at the start of a statement
+			line := script[i:]
+			end := strings.IndexByte(line, '\n')
+			if end >= 0 {
+				line = line[:end]
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 1 {
+				delimiter = fields[1]
+			}
+			i += len(line)
+			current.Reset()
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter) - 1
+		case c == '#' || isDashComment(script[i:]):
+			// Skip to end of line
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*") && !strings.HasPrefix(script[i:], "/*!"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			// Like the server, keep the tokens around it apart
+			current.WriteByte(' ')
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return stmts
+}
+
+// DELIMITER followed by whitespace, in any case
+func isDelimiterCommand(s string) bool {
+	const command = "DELIMITER"
+	if len(s) <= len(command) || !strings.EqualFold(s[:len(command)], command) {
+		return false
+	}
+	return s[len(command)] == ' ' || s[len(command)] == '\t'
+}
+
+// A -- comment needs whitespace (or the end of input) after the dashes
+func isDashComment(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return len(s) == 2 || strings.IndexByte(" \t\r\n", s[2]) >= 0
+}
@@ -0,0 +1,137 @@
+package mysqltest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Returned (wrapped) by Start when the installed server is outside the range
+// given with WithMinVersion / WithMaxVersion. Tests can check for it with
+// errors.Is and skip.
+var ErrUnsupportedVersion = errors.New("Unsupported server version")
+
+// A server version as major, minor, patch.
+type Version [3]int
+
+// Whether v is the given version or newer.
+func (v Version) AtLeast(major, minor, patch int) bool {
+	return v.compare(Version{major, minor, patch}) >= 0
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v Version) compare(o Version) int {
+	for i := range v {
+		if v[i] != o[i] {
+			if v[i] < o[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Server flavors, see Flavor.
+const (
+	FlavorMySQL   = "MySQL"
+	FlavorMariaDB = "MariaDB"
+)
+
+// Flavor of the running server, FlavorMySQL or FlavorMariaDB.
+func (p *MySQL) Flavor() string {
+	if p.isMariaDB {
+		return FlavorMariaDB
+	}
+	return FlavorMySQL
+}
+
+// Version of the running server, zero when it couldn't be determined.
+//
+//	if mysql.Flavor() == mysqltest.FlavorMySQL && mysql.Version().AtLeast(8, 0, 0) {
+//		...
+//	}
+func (p *MySQL) Version() Version {
+	return p.version
+}
+
+var (
+	// MariaDB reports the client version first, the server version follows
+	// "Distrib" (or "from" since 11.x)
+	distribVersion = regexp.MustCompile(`(?:Distrib|from)\s+(\d+)\.(\d+)\.(\d+)`)
+	plainVersion   = regexp.MustCompile(`Ver\s+(\d+)\.(\d+)\.(\d+)`)
+)
+
+// Parse the output of mysql --version.
+func parseVersion(s string) (Version, error) {
+	m := distribVersion.FindStringSubmatch(s)
+	if m == nil {
+		m = plainVersion.FindStringSubmatch(s)
+	}
+	if m == nil {
+		return Version{}, fmt.Errorf("Could not determine version from %q", s)
+	}
+	return versionFromParts(m[1:])
+}
+
+// Parse a version like "8.0" or "10.6.4", missing parts are zero.
+func ParseVersion(s string) (Version, error) {
+	m := regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?$`).FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("Invalid version: %q", s)
+	}
+	return versionFromParts(m[1:])
+}
+
+func versionFromParts(parts []string) (Version, error) {
+	var v Version
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, err
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (c *config) hasVersionRange() bool {
+	return c.minVersion != "" || c.maxVersion != ""
+}
+
+// Check the version against the configured range.
+func (c *config) checkVersion(v Version) error {
+	if c.minVersion != "" {
+		min, err := ParseVersion(c.minVersion)
+		if err != nil {
+			return err
+		}
+		if v.compare(min) < 0 {
+			return fmt.Errorf("%w: %s is older than %s", ErrUnsupportedVersion, v, c.minVersion)
+		}
+	}
+
+	if c.maxVersion != "" {
+		max, err := ParseVersion(c.maxVersion)
+		if err != nil {
+			return err
+		}
+
+		// Only compare the given parts: "8.0" includes 8.0.36
+		parts := len(strings.Split(c.maxVersion, "."))
+		var prefix Version
+		copy(prefix[:parts], v[:parts])
+		if prefix.compare(max) > 0 {
+			return fmt.Errorf("%w: %s is newer than %s", ErrUnsupportedVersion, v, c.maxVersion)
+		}
+	}
+	return nil
+}